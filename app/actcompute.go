@@ -0,0 +1,189 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/act"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/util"
+)
+
+const (
+	// liveAuctionsComputeWorkers matches the worker count of the act client's own compute-live-auctions calls
+	liveAuctionsComputeWorkers = 4
+	// pricelistHistoriesComputeWorkers matches the worker count of the act client's own
+	// compute-pricelist-histories calls
+	pricelistHistoriesComputeWorkers = 12
+)
+
+// actComputeJob is the act response for a single tuple
+type actComputeJob struct {
+	tuple sotah.RegionRealmTimestampTuple
+	data  act.ResponseMeta
+	err   error
+}
+
+// callActCompute calls an act compute endpoint once per tuple as the act client does, but keeps hold of each
+// tuple's target timestamp so that outcomes can be attributed to the exact tuple
+func callActCompute(
+	sta fn.GatewayState,
+	routeEndpoint string,
+	workers int,
+	tuples sotah.RegionRealmTimestampTuples,
+) (chan actComputeJob, error) {
+	endpoints, err := sta.IO.HellClient.GetActEndpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	logging.WithField("endpoint-url", endpoints.Workload).Info("Producing act client for " + routeEndpoint)
+	actClient, err := act.NewClient(endpoints.Workload)
+	if err != nil {
+		return nil, err
+	}
+
+	// establishing channels
+	in := make(chan sotah.RegionRealmTimestampTuple)
+	out := make(chan actComputeJob)
+
+	// spinning up the workers
+	worker := func() {
+		for tuple := range in {
+			body, err := tuple.EncodeForDelivery()
+			if err != nil {
+				out <- actComputeJob{tuple: tuple, err: err}
+
+				continue
+			}
+
+			data, err := actClient.Call(routeEndpoint, "POST", []byte(body))
+			out <- actComputeJob{tuple: tuple, data: data, err: err}
+		}
+	}
+	postWork := func() {
+		close(out)
+	}
+	util.Work(workers, worker, postWork)
+
+	// queueing up the tuples
+	go func() {
+		for _, tuple := range tuples {
+			in <- tuple
+		}
+
+		close(in)
+	}()
+
+	return out, nil
+}
+
+// acceptedActComputeBody hands back the response body of a successful act compute call, logging and reporting the
+// tuple as failed otherwise
+func acceptedActComputeBody(job actComputeJob, progress progressFunc) ([]byte, bool) {
+	fields := logrus.Fields{
+		"region":           job.tuple.RegionName,
+		"realm":            job.tuple.RealmSlug,
+		"target-timestamp": job.tuple.TargetTimestamp,
+	}
+
+	if job.err != nil {
+		fields["error"] = job.err.Error()
+		logging.WithFields(fields).Error("Failed to call act compute endpoint")
+		progress.failed(job.tuple, failureUnreachable)
+
+		return nil, false
+	}
+
+	if job.data.Code != http.StatusCreated {
+		fields["status-code"] = job.data.Code
+		fields["data"] = fmt.Sprintf("%.25s", string(job.data.Body))
+		logging.WithFields(fields).Error("Response code for act call was invalid")
+		progress.failed(job.tuple, failureReasonForStatus(job.data.Code))
+
+		return nil, false
+	}
+
+	return job.data.Body, true
+}
+
+// failureReason categorizes why a tuple was not computed
+type failureReason string
+
+const (
+	// failureUnreachable is an act call that never got a response
+	failureUnreachable failureReason = "unreachable"
+	// failureNotFound is an act service finding nothing stored to compute the tuple from
+	failureNotFound failureReason = "not_found"
+	// failureRejected is an act service refusing the tuple itself
+	failureRejected failureReason = "rejected"
+	// failureComputeFailed is an act service failing while computing or storing the tuple
+	failureComputeFailed failureReason = "compute_failed"
+	// failureDecodeFailed is an act response that could not be decoded
+	failureDecodeFailed failureReason = "decode_failed"
+	// failureUnknown is a tuple whose failure was not observed, as when its compute was coalesced
+	failureUnknown failureReason = "unknown"
+)
+
+// retryable is whether retrying the tuple as-is may succeed, where missing data and rejected tuples will not
+func (reason failureReason) retryable() bool {
+	switch reason {
+	case failureNotFound, failureRejected, failureDecodeFailed:
+		return false
+	default:
+		return true
+	}
+}
+
+func failureReasonForStatus(code int) failureReason {
+	switch {
+	case code == http.StatusNotFound:
+		return failureNotFound
+	case code >= http.StatusInternalServerError:
+		return failureComputeFailed
+	default:
+		return failureRejected
+	}
+}
+
+// computeFailure is why a tuple was not computed
+type computeFailure struct {
+	Reason    failureReason `json:"reason"`
+	Retryable bool          `json:"retryable"`
+}
+
+// tupleFailure is a failed tuple along with why it failed
+type tupleFailure struct {
+	sotah.RegionRealmTimestampTuple
+	computeFailure
+}
+
+// tupleFailures pairs each failed tuple with why it failed, where failures that were not observed are unknown
+func tupleFailures(
+	failed sotah.RegionRealmTimestampTuples,
+	failures map[sotah.RegionRealmTimestampTuple]computeFailure,
+) []tupleFailure {
+	out := []tupleFailure{}
+	for _, tuple := range failed {
+		failure, ok := failures[tuple]
+		if !ok {
+			failure = computeFailure{Reason: failureUnknown, Retryable: failureUnknown.retryable()}
+		}
+
+		out = append(out, tupleFailure{RegionRealmTimestampTuple: tuple, computeFailure: failure})
+	}
+
+	return out
+}
+
+func failedByReason(failures []tupleFailure) map[failureReason]sotah.RegionRealmTimestampTuples {
+	out := map[failureReason]sotah.RegionRealmTimestampTuples{}
+	for _, failure := range failures {
+		out[failure.Reason] = append(out[failure.Reason], failure.RegionRealmTimestampTuple)
+	}
+
+	return out
+}
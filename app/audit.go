@@ -0,0 +1,247 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/hell"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+	"google.golang.org/api/iterator"
+)
+
+const auditCollection = "gateway_audit_entries"
+
+type auditEntry struct {
+	Identity string `firestore:"identity" json:"identity"`
+	// IdentityVerified is false when the identity was read from an unverified bearer token claim
+	IdentityVerified bool     `firestore:"identity_verified" json:"identity_verified"`
+	Route            string   `firestore:"route" json:"route"`
+	Scope            []string `firestore:"scope" json:"scope"`
+	ScopeSize        int      `firestore:"scope_size" json:"scope_size"`
+	Timestamp        int64    `firestore:"timestamp" json:"timestamp"`
+	Status           int      `firestore:"status" json:"status"`
+	Tenant           string   `firestore:"tenant" json:"tenant,omitempty"`
+}
+
+func newAuditEntry(r *http.Request) *auditEntry {
+	identity, verified := requestIdentity(r)
+
+	return &auditEntry{
+		Identity:         identity,
+		IdentityVerified: verified,
+		Route:            r.URL.Path,
+		Scope:            []string{},
+		Timestamp:        time.Now().Unix(),
+	}
+}
+
+func (entry *auditEntry) setTupleScope(tuples sotah.RegionRealmTimestampTuples) {
+	entry.ScopeSize = len(tuples)
+	for _, tuple := range tuples {
+		entry.Scope = append(
+			entry.Scope,
+			fmt.Sprintf("%s/%s/%d", tuple.RegionName, tuple.RealmSlug, tuple.TargetTimestamp),
+		)
+	}
+}
+
+// verifiedIdentityHeader is set by IAP once it has verified the caller, and is stripped from client requests
+const verifiedIdentityHeader = "X-Goog-Authenticated-User-Email"
+
+// requestIdentity resolves the caller, preferring the identity verified by IAP
+// otherwise the email claim of the bearer token is used, which is decoded without its signature being checked
+func requestIdentity(r *http.Request) (string, bool) {
+	if provided := r.Header.Get(verifiedIdentityHeader); provided != "" {
+		return strings.TrimPrefix(provided, "accounts.google.com:"), true
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+	if len(parts) != 3 {
+		return "anonymous", false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "anonymous", false
+	}
+
+	var claims struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Email == "" {
+		return "anonymous", false
+	}
+
+	return claims.Email, false
+}
+
+func newAuditQuery(r *http.Request) (auditQuery, error) {
+	out := auditQuery{Route: r.URL.Query().Get("route"), Limit: 100}
+
+	for key, dest := range map[string]*int64{"since": &out.Since, "until": &out.Until} {
+		provided := r.URL.Query().Get(key)
+		if provided == "" {
+			continue
+		}
+
+		parsed, err := strconv.ParseInt(provided, 10, 64)
+		if err != nil {
+			return auditQuery{}, fmt.Errorf("could not parse %s: %s", key, err.Error())
+		}
+
+		*dest = parsed
+	}
+
+	if provided := r.URL.Query().Get("limit"); provided != "" {
+		limit, err := strconv.Atoi(provided)
+		if err != nil || limit <= 0 || limit > 1000 {
+			return auditQuery{}, errors.New("limit must be between 1 and 1000")
+		}
+
+		out.Limit = limit
+	}
+
+	return out, nil
+}
+
+type auditQuery struct {
+	Route string
+	Since int64
+	Until int64
+	Limit int
+}
+
+type auditStore interface {
+	Write(entry auditEntry) error
+	Query(query auditQuery) ([]auditEntry, error)
+}
+
+func newAuditStore(sta fn.GatewayState) auditStore {
+	return hellAuditStore{client: sta.IO.HellClient}
+}
+
+type hellAuditStore struct {
+	client hell.Client
+}
+
+func (s hellAuditStore) Write(entry auditEntry) error {
+	collection, err := s.client.FirmCollection(auditCollection)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = collection.Add(s.client.Context, entry)
+
+	return err
+}
+
+func (s hellAuditStore) Query(query auditQuery) ([]auditEntry, error) {
+	collection, err := s.client.FirmCollection(auditCollection)
+	if err != nil {
+		return []auditEntry{}, err
+	}
+
+	// filtering on route requires the composite index declared in firestore.indexes.json
+	q := collection.Where("timestamp", ">=", query.Since)
+	if query.Until > 0 {
+		q = q.Where("timestamp", "<=", query.Until)
+	}
+	if query.Route != "" {
+		q = q.Where("route", "==", query.Route)
+	}
+	q = q.OrderBy("timestamp", firestore.Desc).Limit(query.Limit)
+
+	out := []auditEntry{}
+	it := q.Documents(s.client.Context)
+	for {
+		docsnap, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+
+			return []auditEntry{}, err
+		}
+
+		var entry auditEntry
+		if err := docsnap.DataTo(&entry); err != nil {
+			return []auditEntry{}, err
+		}
+
+		out = append(out, entry)
+	}
+
+	return out, nil
+}
+
+func recordAuditEntry(sta fn.GatewayState, entry *auditEntry, recorder *statusRecorder) {
+	if entry == nil {
+		return
+	}
+
+	entry.Status = recorder.status
+
+	if err := newAuditStore(sta).Write(*entry); err != nil {
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"route": entry.Route,
+		}).Error("Failed to write audit entry")
+	}
+}
+
+// statusRecorder captures the response status so that it can be audited
+// the start of server-side erroneous response bodies is also kept, so that it can be recorded as an error event
+type statusRecorder struct {
+	http.ResponseWriter
+	status    int
+	errorBody []byte
+
+	// cacheControl is applied to successful responses only, so that errors are never cached
+	cacheControl string
+	wroteHeader  bool
+
+	// captureLimit is how much of the response body to keep in captured, where zero disables capturing
+	captureLimit int
+	captured     []byte
+	bytesWritten int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.wroteHeader = true
+	if rec.cacheControl != "" && code < http.StatusMultipleChoices {
+		rec.Header().Set("Cache-Control", rec.cacheControl)
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(body []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+
+	if rec.status >= http.StatusInternalServerError {
+		rec.errorBody = appendCapped(rec.errorBody, body, errorEventMessageLimit)
+	}
+	rec.captured = appendCapped(rec.captured, body, rec.captureLimit)
+	rec.bytesWritten += len(body)
+
+	return rec.ResponseWriter.Write(body)
+}
+
+// Flush passes flushes through, so that streaming routes are unaffected by recording
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
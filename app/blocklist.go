@@ -0,0 +1,52 @@
+package app
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+)
+
+// syncBlocklist holds the item-ids that are never synced, as they are known to always fail
+var syncBlocklist = map[blizzard.ItemID]struct{}{}
+
+func parseSyncBlocklist() (map[blizzard.ItemID]struct{}, error) {
+	out := map[blizzard.ItemID]struct{}{}
+	for _, provided := range strings.Split(os.Getenv("SYNC_BLOCKLIST"), ",") {
+		provided = strings.TrimSpace(provided)
+		if provided == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(provided)
+		if err != nil {
+			return map[blizzard.ItemID]struct{}{}, err
+		}
+
+		out[blizzard.ItemID(id)] = struct{}{}
+	}
+
+	return out, nil
+}
+
+type syncItemsResponse struct {
+	Blocked blizzard.ItemIds `json:"blocked"`
+}
+
+// filterBlockedItemIds separates the item-ids to sync from those on the sync blocklist
+func filterBlockedItemIds(ids blizzard.ItemIds) (blizzard.ItemIds, blizzard.ItemIds) {
+	allowed := blizzard.ItemIds{}
+	blocked := blizzard.ItemIds{}
+	for _, id := range ids {
+		if _, ok := syncBlocklist[id]; ok {
+			blocked = append(blocked, id)
+
+			continue
+		}
+
+		allowed = append(allowed, id)
+	}
+
+	return allowed, blocked
+}
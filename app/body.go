@@ -0,0 +1,261 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+)
+
+// bodyWarnBytes and bodyWarnDuration are the thresholds past which a body read is logged at warn
+var bodyWarnBytes int64 = 1024 * 1024
+var bodyWarnDuration = 5 * time.Second
+
+func parseBodyWarnThresholds() (int64, time.Duration, error) {
+	warnBytes := int64(1024 * 1024)
+	if provided := os.Getenv("BODY_WARN_BYTES"); provided != "" {
+		parsed, err := strconv.ParseInt(provided, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		if parsed <= 0 {
+			return 0, 0, errors.New("body warn bytes must be positive")
+		}
+
+		warnBytes = parsed
+	}
+
+	warnDuration := 5 * time.Second
+	if provided := os.Getenv("BODY_WARN_SECONDS"); provided != "" {
+		parsed, err := strconv.Atoi(provided)
+		if err != nil {
+			return 0, 0, err
+		}
+		if parsed <= 0 {
+			return 0, 0, errors.New("body warn seconds must be positive")
+		}
+
+		warnDuration = time.Duration(parsed) * time.Second
+	}
+
+	return warnBytes, warnDuration, nil
+}
+
+// bodyReadTimeout bounds how long reading the request body may take, where zero disables it
+var bodyReadTimeout time.Duration
+
+func parseBodyReadTimeout() (time.Duration, error) {
+	provided := os.Getenv("BODY_READ_TIMEOUT_SECONDS")
+	if provided == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 0 {
+		return 0, errors.New("body read timeout seconds must not be negative")
+	}
+
+	return time.Duration(parsed) * time.Second, nil
+}
+
+// maxBodyBytes bounds how much of a request body may be read, where zero disables it
+var maxBodyBytes int64
+
+// routeMaxBodyBytes overrides maxBodyBytes per route, keyed by the env name derived from the route path
+var routeMaxBodyBytes = map[string]int64{}
+
+const maxBodyEnvPrefix = "MAX_BODY_"
+
+// maxBodyEnvName derives the env name of a route's max-body override, e.g. MAX_BODY_ITEMS_PURGE for /items/purge
+func maxBodyEnvName(routePath string) string {
+	name := strings.NewReplacer("/", "_", "-", "_").Replace(strings.Trim(routePath, "/"))
+
+	return maxBodyEnvPrefix + strings.ToUpper(name)
+}
+
+// parseMaxBodyBytes reads the global MAX_BODY_BYTES and every MAX_BODY_<ROUTE> override
+func parseMaxBodyBytes() (int64, map[string]int64, error) {
+	parse := func(name string, provided string) (int64, error) {
+		parsed, err := strconv.ParseInt(provided, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if parsed < 0 {
+			return 0, fmt.Errorf("%s must not be negative", name)
+		}
+
+		return parsed, nil
+	}
+
+	global := int64(0)
+	overrides := map[string]int64{}
+	for _, pair := range os.Environ() {
+		parts := strings.SplitN(pair, "=", 2)
+		if !strings.HasPrefix(parts[0], maxBodyEnvPrefix) || len(parts) != 2 {
+			continue
+		}
+
+		parsed, err := parse(parts[0], parts[1])
+		if err != nil {
+			return 0, map[string]int64{}, err
+		}
+
+		if parts[0] == "MAX_BODY_BYTES" {
+			global = parsed
+
+			continue
+		}
+
+		overrides[parts[0]] = parsed
+	}
+
+	return global, overrides, nil
+}
+
+// unknownMaxBodyOverrides lists the MAX_BODY_<ROUTE> overrides that name no route, e.g. a misspelt or shortened
+// route name, which would otherwise be silently ignored
+func unknownMaxBodyOverrides(overrides map[string]int64, rts []route) []string {
+	known := map[string]struct{}{}
+	for _, rt := range rts {
+		known[maxBodyEnvName(rt.Path)] = struct{}{}
+	}
+
+	out := []string{}
+	for name := range overrides {
+		if _, ok := known[name]; !ok {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+// maxBodyFor resolves the max-body of a route, preferring its override over the global limit
+func maxBodyFor(routePath string) int64 {
+	if limit, ok := routeMaxBodyBytes[maxBodyEnvName(routePath)]; ok {
+		return limit
+	}
+
+	return maxBodyBytes
+}
+
+var errBodyReadTimeout = errors.New("timed out reading request body")
+
+var errBodyTooLarge = errors.New("request body is too large")
+
+// writeBodyReadError answers body reads that failed on the read timeout with 408, or on the max-body with 413,
+// flagging whether it did so
+func writeBodyReadError(w http.ResponseWriter, err error) bool {
+	switch err {
+	case errBodyReadTimeout:
+		writeErroneousResponse(w, http.StatusRequestTimeout, "Timed out reading request body")
+	case errBodyTooLarge:
+		writeErroneousResponse(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+	default:
+		return false
+	}
+
+	return true
+}
+
+// meteredBody is the shared request body reader, tracking how much was read and how long reading took
+type meteredBody struct {
+	io.ReadCloser
+
+	deadline  time.Time
+	limit     int64
+	bytesRead int64
+	firstRead time.Time
+	lastRead  time.Time
+}
+
+func newMeteredBody(body io.ReadCloser, limit int64) *meteredBody {
+	out := &meteredBody{ReadCloser: body, limit: limit}
+	if bodyReadTimeout > 0 {
+		out.deadline = time.Now().Add(bodyReadTimeout)
+	}
+
+	return out
+}
+
+func (body *meteredBody) Read(p []byte) (int, error) {
+	if body.firstRead.IsZero() {
+		body.firstRead = time.Now()
+	}
+
+	n, err := body.boundedRead(p)
+	body.bytesRead += int64(n)
+	body.lastRead = time.Now()
+	if body.limit > 0 && body.bytesRead > body.limit {
+		return n, errBodyTooLarge
+	}
+
+	return n, err
+}
+
+type bodyReadResult struct {
+	data []byte
+	err  error
+}
+
+// boundedRead gives up on the underlying read once the deadline passes
+// the read goes into its own buffer, so that a read completing after the deadline cannot write into p
+func (body *meteredBody) boundedRead(p []byte) (int, error) {
+	if body.deadline.IsZero() {
+		return body.ReadCloser.Read(p)
+	}
+
+	remaining := time.Until(body.deadline)
+	if remaining <= 0 {
+		return 0, errBodyReadTimeout
+	}
+
+	done := make(chan bodyReadResult, 1)
+	go func() {
+		buf := make([]byte, len(p))
+		n, err := body.ReadCloser.Read(buf)
+		done <- bodyReadResult{data: buf[:n], err: err}
+	}()
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+
+	select {
+	case result := <-done:
+		return copy(p, result.data), result.err
+	case <-timer.C:
+		return 0, errBodyReadTimeout
+	}
+}
+
+func (body *meteredBody) report(route string) {
+	if body.firstRead.IsZero() {
+		return
+	}
+
+	duration := body.lastRead.Sub(body.firstRead)
+	entry := logging.WithFields(logrus.Fields{
+		"route":          route,
+		"bytes-read":     body.bytesRead,
+		"duration-in-ms": int64(duration / time.Millisecond),
+	})
+	if body.bytesRead > bodyWarnBytes || duration > bodyWarnDuration {
+		entry.Warn("Request body read was large or slow")
+
+		return
+	}
+
+	entry.Debug("Read request body")
+}
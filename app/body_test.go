@@ -0,0 +1,138 @@
+package app
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// withEnv sets the env vars, returning a func that unsets them again
+func withEnv(env map[string]string) func() {
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+
+	return func() {
+		for name := range env {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestParseMaxBodyBytes(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		wantGlobal    int64
+		wantOverrides map[string]int64
+		wantErr       bool
+	}{
+		{
+			name:          "unset",
+			env:           map[string]string{},
+			wantGlobal:    0,
+			wantOverrides: map[string]int64{},
+		},
+		{
+			name:          "global and override",
+			env:           map[string]string{"MAX_BODY_BYTES": "1024", "MAX_BODY_ITEMS_PURGE": "64"},
+			wantGlobal:    1024,
+			wantOverrides: map[string]int64{"MAX_BODY_ITEMS_PURGE": 64},
+		},
+		{
+			name:    "negative",
+			env:     map[string]string{"MAX_BODY_BYTES": "-1"},
+			wantErr: true,
+		},
+		{
+			name:    "not a number",
+			env:     map[string]string{"MAX_BODY_ITEMS_PURGE": "lots"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer withEnv(test.env)()
+
+			global, overrides, err := parseMaxBodyBytes()
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %d and %+v", global, overrides)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if global != test.wantGlobal {
+				t.Errorf("got global %d, want %d", global, test.wantGlobal)
+			}
+			if !reflect.DeepEqual(overrides, test.wantOverrides) {
+				t.Errorf("got overrides %+v, want %+v", overrides, test.wantOverrides)
+			}
+		})
+	}
+}
+
+func TestMaxBodyEnvName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/items/purge", want: "MAX_BODY_ITEMS_PURGE"},
+		{path: "/compute-all-pricelist-histories", want: "MAX_BODY_COMPUTE_ALL_PRICELIST_HISTORIES"},
+		{path: "/", want: "MAX_BODY_"},
+	}
+
+	for _, test := range tests {
+		if got := maxBodyEnvName(test.path); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.path, got, test.want)
+		}
+	}
+}
+
+func TestUnknownMaxBodyOverrides(t *testing.T) {
+	rts := []route{{Path: "/items/purge"}, {Path: "/compute-all-pricelist-histories"}}
+	tests := []struct {
+		name      string
+		overrides map[string]int64
+		want      []string
+	}{
+		{
+			name:      "none",
+			overrides: map[string]int64{},
+			want:      []string{},
+		},
+		{
+			name:      "all known",
+			overrides: map[string]int64{"MAX_BODY_ITEMS_PURGE": 1, "MAX_BODY_COMPUTE_ALL_PRICELIST_HISTORIES": 1},
+			want:      []string{},
+		},
+		{
+			name:      "shortened route name",
+			overrides: map[string]int64{"MAX_BODY_COMPUTE_PRICELIST": 1, "MAX_BODY_ITEMS_PURGE": 1, "MAX_BODY_A": 1},
+			want:      []string{"MAX_BODY_A", "MAX_BODY_COMPUTE_PRICELIST"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := unknownMaxBodyOverrides(test.overrides, rts)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRoutesHaveNoUnknownMaxBodyOverrides(t *testing.T) {
+	for _, rt := range routes {
+		overrides := map[string]int64{maxBodyEnvName(rt.Path): 1}
+		if unknown := unknownMaxBodyOverrides(overrides, routes); len(unknown) > 0 {
+			t.Errorf("%s: override %s was not recognized", rt.Path, unknown[0])
+		}
+	}
+}
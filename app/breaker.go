@@ -0,0 +1,82 @@
+package app
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// breakerCooldown is how long a dependency is considered down after a failed ping
+const breakerCooldown = 30 * time.Second
+
+// dependencyBreakers tracks, per dependency, when it may next be considered up
+var dependencyBreakers = struct {
+	sync.Mutex
+	openUntil map[dependencyName]time.Time
+}{openUntil: map[dependencyName]time.Time{}}
+
+func tripBreaker(name dependencyName) {
+	dependencyBreakers.Lock()
+	defer dependencyBreakers.Unlock()
+
+	dependencyBreakers.openUntil[name] = time.Now().Add(breakerCooldown)
+}
+
+func resetBreaker(name dependencyName) {
+	dependencyBreakers.Lock()
+	defer dependencyBreakers.Unlock()
+
+	delete(dependencyBreakers.openUntil, name)
+}
+
+// openDependencies filters the given dependencies down to those whose breakers are open
+func openDependencies(names []dependencyName) []dependencyName {
+	dependencyBreakers.Lock()
+	defer dependencyBreakers.Unlock()
+
+	out := []dependencyName{}
+	for _, name := range names {
+		if time.Now().Before(dependencyBreakers.openUntil[name]) {
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
+
+type breakerState struct {
+	Name      dependencyName `json:"name"`
+	Open      bool           `json:"open"`
+	OpenUntil int64          `json:"open_until,omitempty"`
+}
+
+// breakerStates lists the breaker of every dependency, in the same order as the dependency pings
+func breakerStates() []breakerState {
+	dependencyBreakers.Lock()
+	defer dependencyBreakers.Unlock()
+
+	out := []breakerState{}
+	for _, dependency := range dependencyPings {
+		state := breakerState{Name: dependency.name}
+		if openUntil, ok := dependencyBreakers.openUntil[dependency.name]; ok && time.Now().Before(openUntil) {
+			state.Open = true
+			state.OpenUntil = openUntil.Unix()
+		}
+
+		out = append(out, state)
+	}
+
+	return out
+}
+
+type limitsResponse struct {
+	Breakers           []breakerState `json:"breakers"`
+	OperationsInFlight int64          `json:"operations_in_flight"`
+}
+
+func currentLimits() limitsResponse {
+	return limitsResponse{
+		Breakers:           breakerStates(),
+		OperationsInFlight: atomic.LoadInt64(&operationsInFlight),
+	}
+}
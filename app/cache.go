@@ -0,0 +1,57 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cacheMaxAges maps read route paths to the max-age, in seconds, that caches may keep their successful responses
+var cacheMaxAges = map[string]int{}
+
+// parseCacheMaxAges reads the comma-separated path=seconds pairs of CACHE_MAX_AGES
+func parseCacheMaxAges() (map[string]int, error) {
+	out := map[string]int{}
+	for _, provided := range strings.Split(os.Getenv("CACHE_MAX_AGES"), ",") {
+		provided = strings.TrimSpace(provided)
+		if provided == "" {
+			continue
+		}
+
+		parts := strings.SplitN(provided, "=", 2)
+		if len(parts) != 2 {
+			return map[string]int{}, fmt.Errorf("cache max age %s must be of the form path=seconds", provided)
+		}
+		if !strings.HasPrefix(parts[0], "/") {
+			return map[string]int{}, fmt.Errorf("cache max age path %s must start with /", parts[0])
+		}
+
+		seconds, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return map[string]int{}, err
+		}
+		if seconds < 0 {
+			return map[string]int{}, errors.New("cache max age seconds must not be negative")
+		}
+
+		out[parts[0]] = seconds
+	}
+
+	return out, nil
+}
+
+// cacheControlFor resolves the Cache-Control of a read route's successful responses, where unconfigured routes are
+// left to the defaults of the caches and admin routes may only be kept by the client itself
+func cacheControlFor(rt route) string {
+	seconds, ok := cacheMaxAges[rt.Path]
+	if !ok || rt.Mutating {
+		return ""
+	}
+	if rt.Admin {
+		return fmt.Sprintf("private, max-age=%d", seconds)
+	}
+
+	return fmt.Sprintf("public, max-age=%d", seconds)
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+)
+
+// debugCapture is whether the last exchange of each route is kept for /admin/last, bodies included as sent
+var debugCapture bool
+
+// debugCaptureBytes bounds how much of each request and response body is kept
+var debugCaptureBytes = 4096
+
+func parseDebugCaptureBytes() (int, error) {
+	provided := os.Getenv("DEBUG_CAPTURE_BYTES")
+	if provided == "" {
+		return 4096, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed <= 0 {
+		return 0, errors.New("debug capture bytes must be positive")
+	}
+
+	return parsed, nil
+}
+
+// appendCapped appends as much of src as fits within limit
+func appendCapped(dst []byte, src []byte, limit int) []byte {
+	remaining := limit - len(dst)
+	if remaining <= 0 {
+		return dst
+	}
+	if len(src) < remaining {
+		remaining = len(src)
+	}
+
+	return append(dst, src[:remaining]...)
+}
+
+// capturedBody keeps the head of the request body as it is read by the handler
+type capturedBody struct {
+	io.ReadCloser
+
+	data      []byte
+	bytesRead int
+}
+
+func (body *capturedBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.data = appendCapped(body.data, p[:n], debugCaptureBytes)
+	body.bytesRead += n
+
+	return n, err
+}
+
+type capturedExchange struct {
+	Method                string      `json:"method"`
+	Query                 string      `json:"query"`
+	Headers               http.Header `json:"headers"`
+	RequestBody           string      `json:"request_body"`
+	RequestBodyTruncated  bool        `json:"request_body_truncated"`
+	Status                int         `json:"status"`
+	ResponseBody          string      `json:"response_body"`
+	ResponseBodyTruncated bool        `json:"response_body_truncated"`
+	Timestamp             int64       `json:"timestamp"`
+}
+
+// captureStore holds the last exchange per route path
+type captureStore struct {
+	sync.Mutex
+
+	exchanges map[string]capturedExchange
+}
+
+var captures = &captureStore{exchanges: map[string]capturedExchange{}}
+
+func (store *captureStore) set(routePath string, exchange capturedExchange) {
+	store.Lock()
+	defer store.Unlock()
+
+	store.exchanges[routePath] = exchange
+}
+
+func (store *captureStore) get(routePath string) (capturedExchange, bool) {
+	store.Lock()
+	defer store.Unlock()
+
+	exchange, ok := store.exchanges[routePath]
+
+	return exchange, ok
+}
+
+// captureExchange records the exchange once the route has responded, with headers redacted as they are for logging
+// bodies are captured raw up to DEBUG_CAPTURE_BYTES, as the redaction rules only name headers, so DEBUG_CAPTURE
+// should only be enabled where request and response bodies may be read by admins
+func captureExchange(r *http.Request, routePath string, body *capturedBody, recorder *statusRecorder) {
+	captures.set(routePath, capturedExchange{
+		Method:                r.Method,
+		Query:                 r.URL.RawQuery,
+		Headers:               redactHeaders(r.Header),
+		RequestBody:           string(body.data),
+		RequestBodyTruncated:  body.bytesRead > len(body.data),
+		Status:                recorder.status,
+		ResponseBody:          string(recorder.captured),
+		ResponseBodyTruncated: recorder.bytesWritten > len(recorder.captured),
+		Timestamp:             time.Now().Unix(),
+	})
+}
+
+func handleAdminLast(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	if !isAdmin(r) {
+		writeErroneousResponse(w, http.StatusForbidden, "Admin token required")
+
+		return
+	}
+	if !debugCapture {
+		writeErroneousResponse(w, http.StatusNotFound, "Debug capture is disabled")
+
+		return
+	}
+
+	rt, ok := findRoute(r.URL.Query().Get("route"))
+	if !ok {
+		writeErroneousResponse(w, http.StatusBadRequest, "Route not found")
+
+		return
+	}
+
+	exchange, ok := captures.get(rt.Path)
+	if !ok {
+		writeErroneousResponse(w, http.StatusNotFound, "No exchange captured for route")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, exchange)
+}
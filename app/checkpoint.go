@@ -0,0 +1,253 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const downloadCheckpointPath = "gateway_download_checkpoints/all-auctions"
+
+// downloadChunkSize is the number of realms downloaded between checkpoints
+const downloadChunkSize = 10
+
+// downloadCycle is how long a download checkpoint is resumed from before a new cycle starts, where zero disables
+// checkpointing
+var downloadCycle time.Duration
+
+func parseDownloadCycle() (time.Duration, error) {
+	provided := os.Getenv("DOWNLOAD_CYCLE_SECONDS")
+	if provided == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 0 {
+		return 0, errors.New("download cycle seconds must not be negative")
+	}
+
+	return time.Duration(parsed) * time.Second, nil
+}
+
+// downloadCheckpoint lists the realms, by region/realm cursor, already downloaded within the current cycle, along
+// with the downloaded tuples not yet handed on to be received and computed
+type downloadCheckpoint struct {
+	CycleStarted int64          `firestore:"cycle_started"`
+	Completed    []string       `firestore:"completed"`
+	Pending      []pendingTuple `firestore:"pending"`
+	SizeBytes    int            `firestore:"size_bytes"`
+}
+
+// pendingTuple is a downloaded tuple kept on the checkpoint until it is published
+type pendingTuple struct {
+	RegionName      string `firestore:"region_name"`
+	RealmSlug       string `firestore:"realm_slug"`
+	TargetTimestamp int    `firestore:"target_timestamp"`
+}
+
+func newDownloadCheckpoint() downloadCheckpoint {
+	return downloadCheckpoint{CycleStarted: time.Now().Unix(), Completed: []string{}, Pending: []pendingTuple{}}
+}
+
+func (checkpoint downloadCheckpoint) pendingTuples() sotah.RegionRealmTimestampTuples {
+	out := sotah.RegionRealmTimestampTuples{}
+	for _, pending := range checkpoint.Pending {
+		out = append(out, sotah.RegionRealmTimestampTuple{
+			RegionRealmTuple: sotah.RegionRealmTuple{RegionName: pending.RegionName, RealmSlug: pending.RealmSlug},
+			TargetTimestamp:  pending.TargetTimestamp,
+		})
+	}
+
+	return out
+}
+
+// getDownloadCheckpoint fetches the checkpoint of the current cycle, starting a new one when it has lapsed
+func getDownloadCheckpoint(sta fn.GatewayState) (downloadCheckpoint, error) {
+	docsnap, err := sta.IO.HellClient.Doc(downloadCheckpointPath).Get(sta.IO.HellClient.Context)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return newDownloadCheckpoint(), nil
+		}
+
+		return downloadCheckpoint{}, err
+	}
+
+	var checkpoint downloadCheckpoint
+	if err := docsnap.DataTo(&checkpoint); err != nil {
+		return downloadCheckpoint{}, err
+	}
+	if time.Since(time.Unix(checkpoint.CycleStarted, 0)) > downloadCycle {
+		return newDownloadCheckpoint(), nil
+	}
+
+	return checkpoint, nil
+}
+
+func saveDownloadCheckpoint(sta fn.GatewayState, checkpoint downloadCheckpoint) error {
+	_, err := sta.IO.HellClient.Doc(downloadCheckpointPath).Set(sta.IO.HellClient.Context, checkpoint)
+
+	return err
+}
+
+// uncheckpointedRealms lists the realms, in cursor order, that are not among the completed cursors
+func uncheckpointedRealms(regionRealms sotah.RegionRealms, completed []string) []cursoredRealm {
+	done := map[string]struct{}{}
+	for _, cursor := range completed {
+		done[cursor] = struct{}{}
+	}
+
+	out := []cursoredRealm{}
+	for _, realm := range sortedRealms(regionRealms) {
+		if _, ok := done[realm.cursor]; !ok {
+			out = append(out, realm)
+		}
+	}
+
+	return out
+}
+
+type checkpointedDownloadResponse struct {
+	Skipped    int      `json:"skipped"`
+	Downloaded int      `json:"downloaded"`
+	Failed     []string `json:"failed"`
+}
+
+// downloadAllFromCheckpoint downloads every realm not yet downloaded within the current cycle in chunks,
+// checkpointing after each chunk so that a re-invocation resumes where this one stopped
+// only realms that were downloaded or had no new auctions are checkpointed, failed realms are reported and left
+// for a re-invocation to retry
+// the downloaded tuples are kept on the checkpoint and published once after the final chunk, so that a download
+// is received and computed as a whole rather than per chunk, including tuples left by an invocation that stopped
+func downloadAllFromCheckpoint(sta fn.GatewayState, force bool) (checkpointedDownloadResponse, error) {
+	startTime := time.Now()
+
+	regionRealms, err := getAllRegionRealms(sta)
+	if err != nil {
+		return checkpointedDownloadResponse{}, err
+	}
+
+	checkpoint := newDownloadCheckpoint()
+	if !force {
+		checkpoint, err = getDownloadCheckpoint(sta)
+		if err != nil {
+			return checkpointedDownloadResponse{}, err
+		}
+	}
+
+	remaining := uncheckpointedRealms(regionRealms, checkpoint.Completed)
+
+	out := checkpointedDownloadResponse{Skipped: regionRealms.TotalRealms() - len(remaining), Failed: []string{}}
+	for len(remaining) > 0 {
+		chunk := remaining
+		if len(chunk) > downloadChunkSize {
+			chunk = chunk[:downloadChunkSize]
+		}
+		remaining = remaining[len(chunk):]
+
+		chunkRegionRealms := sotah.RegionRealms{}
+		for _, realm := range chunk {
+			chunkRegionRealms[realm.realm.Region.Name] = append(chunkRegionRealms[realm.realm.Region.Name], realm.realm)
+		}
+		downloads, err := downloadAuctions(sta, chunkRegionRealms)
+		if err != nil {
+			return checkpointedDownloadResponse{}, err
+		}
+
+		for _, tuple := range downloads.tuples {
+			checkpoint.Pending = append(checkpoint.Pending, pendingTuple{
+				RegionName:      tuple.RegionName,
+				RealmSlug:       tuple.RealmSlug,
+				TargetTimestamp: tuple.TargetTimestamp,
+			})
+		}
+		checkpoint.SizeBytes += downloads.sizeBytes
+
+		succeeded := map[string]struct{}{}
+		for _, tuple := range downloads.tuples {
+			succeeded[fmt.Sprintf("%s/%s", tuple.RegionName, tuple.RealmSlug)] = struct{}{}
+		}
+		for _, tuple := range downloads.unchanged {
+			succeeded[fmt.Sprintf("%s/%s", tuple.RegionName, tuple.RealmSlug)] = struct{}{}
+		}
+		for _, realm := range chunk {
+			if _, ok := succeeded[realm.cursor]; !ok {
+				out.Failed = append(out.Failed, realm.cursor)
+
+				continue
+			}
+
+			checkpoint.Completed = append(checkpoint.Completed, realm.cursor)
+			out.Downloaded++
+		}
+
+		// carrying on when the checkpoint cannot be saved, as a lost checkpoint only costs a re-download
+		if err := saveDownloadCheckpoint(sta, checkpoint); err != nil {
+			logging.WithField("error", err.Error()).Error("Failed to save download checkpoint")
+		}
+	}
+
+	// publishing every downloaded tuple of the cycle at once
+	err = publishDownloads(
+		sta,
+		checkpoint.pendingTuples(),
+		regionRealms.TotalRealms(),
+		checkpoint.SizeBytes,
+		time.Since(startTime),
+	)
+	if err != nil {
+		return checkpointedDownloadResponse{}, err
+	}
+
+	checkpoint.Pending = []pendingTuple{}
+	checkpoint.SizeBytes = 0
+	if err := saveDownloadCheckpoint(sta, checkpoint); err != nil {
+		logging.WithField("error", err.Error()).Error("Failed to save download checkpoint")
+	}
+
+	return out, nil
+}
+
+// handleCheckpointedDownload downloads all auctions, resuming from the checkpoint unless forced
+func handleCheckpointedDownload(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	resp, err := downloadAllFromCheckpoint(sta, r.URL.Query().Get("force") == "true")
+	if err != nil {
+		if writeContextError(w, r, err) {
+			return
+		}
+
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not call download-all-auctions")
+
+		logging.WithField("error", err.Error()).Error("Could not call download-all-auctions")
+
+		return
+	}
+
+	audit.ScopeSize = resp.Downloaded
+
+	logging.WithFields(logrus.Fields{
+		"skipped":    resp.Skipped,
+		"downloaded": resp.Downloaded,
+		"failed":     len(resp.Failed),
+	}).Info("Downloaded all auctions from checkpoint")
+
+	if len(resp.Failed) > 0 {
+		writeJSONResponse(w, partialSuccessStatus, resp)
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, resp)
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+)
+
+func newTestRealm(regionName string, realmSlug string) sotah.Realm {
+	return sotah.Realm{
+		Realm:  blizzard.Realm{Slug: blizzard.RealmSlug(realmSlug)},
+		Region: sotah.Region{Name: blizzard.RegionName(regionName)},
+	}
+}
+
+func cursorsOf(realms []cursoredRealm) []string {
+	out := []string{}
+	for _, realm := range realms {
+		out = append(out, realm.cursor)
+	}
+
+	return out
+}
+
+func TestSortedRealms(t *testing.T) {
+	tests := []struct {
+		name         string
+		regionRealms sotah.RegionRealms
+		want         []string
+	}{
+		{
+			name:         "empty",
+			regionRealms: sotah.RegionRealms{},
+			want:         []string{},
+		},
+		{
+			name: "ordered by region then realm",
+			regionRealms: sotah.RegionRealms{
+				"us": sotah.Realms{newTestRealm("us", "zuljin"), newTestRealm("us", "aegwynn")},
+				"eu": sotah.Realms{newTestRealm("eu", "silvermoon")},
+			},
+			want: []string{"eu/silvermoon", "us/aegwynn", "us/zuljin"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := cursorsOf(sortedRealms(test.regionRealms))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUncheckpointedRealms(t *testing.T) {
+	regionRealms := sotah.RegionRealms{
+		"us": sotah.Realms{newTestRealm("us", "aegwynn"), newTestRealm("us", "zuljin")},
+		"eu": sotah.Realms{newTestRealm("eu", "silvermoon")},
+	}
+	tests := []struct {
+		name      string
+		completed []string
+		want      []string
+	}{
+		{
+			name:      "nothing completed",
+			completed: []string{},
+			want:      []string{"eu/silvermoon", "us/aegwynn", "us/zuljin"},
+		},
+		{
+			name:      "some completed out of order",
+			completed: []string{"us/zuljin", "eu/silvermoon"},
+			want:      []string{"us/aegwynn"},
+		},
+		{
+			name:      "all completed",
+			completed: []string{"eu/silvermoon", "us/aegwynn", "us/zuljin"},
+			want:      []string{},
+		},
+		{
+			name:      "completed realm no longer in the catalog",
+			completed: []string{"us/removed"},
+			want:      []string{"eu/silvermoon", "us/aegwynn", "us/zuljin"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := cursorsOf(uncheckpointedRealms(regionRealms, test.completed))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDownloadCheckpointPendingTuples(t *testing.T) {
+	checkpoint := newDownloadCheckpoint()
+	checkpoint.Pending = append(
+		checkpoint.Pending,
+		pendingTuple{RegionName: "us", RealmSlug: "aegwynn", TargetTimestamp: 1},
+		pendingTuple{RegionName: "eu", RealmSlug: "silvermoon", TargetTimestamp: 2},
+	)
+
+	want := sotah.RegionRealmTimestampTuples{newTestTuple("us", "aegwynn", 1), newTestTuple("eu", "silvermoon", 2)}
+	if got := checkpoint.pendingTuples(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
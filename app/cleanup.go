@@ -0,0 +1,421 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/act"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/metric"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+)
+
+// cleanupChunkSize is the number of realms cleaned up between deadline checks
+const cleanupChunkSize = 10
+
+// cleanupDeadline is the soft deadline past which a cleanup stops and hands back a cursor, where zero disables it
+// it is opt-in, as callers that do not pass the cursor back would otherwise only ever clean up the first realms
+var cleanupDeadline time.Duration
+
+func parseCleanupDeadline() (time.Duration, error) {
+	provided := os.Getenv("CLEANUP_DEADLINE_SECONDS")
+	if provided == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 0 {
+		return 0, errors.New("cleanup deadline seconds must not be negative")
+	}
+
+	return time.Duration(parsed) * time.Second, nil
+}
+
+// cleanupResponse is what a resumable cleanup affected within this invocation, along with where to resume from
+type cleanupResponse struct {
+	Resume bool   `json:"resume"`
+	Cursor string `json:"cursor,omitempty"`
+	cleanupCounts
+}
+
+// cleanupCounts is what a cleanup affected, where realms that could not be cleaned up are counted as failed
+type cleanupCounts struct {
+	Realms  int  `json:"realms"`
+	Deleted int  `json:"deleted"`
+	Failed  int  `json:"failed"`
+	NoOp    bool `json:"no_op"`
+}
+
+// tally counts a single realm's act cleanup response, decoding how many objects were deleted from its body
+func (counts *cleanupCounts) tally(
+	name string,
+	tuple sotah.RegionRealmTuple,
+	data act.ResponseMeta,
+	err error,
+	decode func(body string) (int, error),
+) {
+	counts.Realms++
+
+	fields := logrus.Fields{
+		"target": name,
+		"region": tuple.RegionName,
+		"realm":  tuple.RealmSlug,
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+		logging.WithFields(fields).Error("Failed to call act cleanup endpoint")
+		counts.Failed++
+
+		return
+	}
+	if data.Code != http.StatusOK {
+		fields["status-code"] = data.Code
+		fields["data"] = fmt.Sprintf("%.25s", string(data.Body))
+		logging.WithFields(fields).Error("Response code for act call was invalid")
+		counts.Failed++
+
+		return
+	}
+
+	deleted, err := decode(string(data.Body))
+	if err != nil {
+		fields["error"] = err.Error()
+		logging.WithFields(fields).Error("Failed to decode cleanup response from act response body")
+		counts.Failed++
+
+		return
+	}
+
+	counts.Deleted += deleted
+}
+
+// cleanupManifests follows the same sequence as CleanupRegionRealmsManifests, counting what was deleted
+func cleanupManifests(sta fn.GatewayState, regionRealms sotah.RegionRealms) (cleanupCounts, error) {
+	endpoints, err := sta.IO.HellClient.GetActEndpoints()
+	if err != nil {
+		return cleanupCounts{}, err
+	}
+
+	actClient, err := act.NewClient(endpoints.CleanupManifests)
+	if err != nil {
+		return cleanupCounts{}, err
+	}
+
+	startTime := time.Now()
+	counts := cleanupCounts{}
+	for outJob := range actClient.CleanupManifests(regionRealms) {
+		counts.tally("manifests", outJob.RegionRealmTuple, outJob.Data, outJob.Err, func(body string) (int, error) {
+			resp, err := sotah.NewCleanupManifestPayloadResponse(body)
+
+			return resp.TotalDeleted, err
+		})
+	}
+	counts.NoOp = counts.Deleted == 0
+
+	// reporting metrics
+	if err := sta.IO.BusClient.PublishMetrics(metric.Metrics{
+		"cleanup_all_manifests_duration":      int(time.Since(startTime) / time.Second),
+		"cleanup_all_manifests_total_deleted": counts.Deleted,
+	}); err != nil {
+		return cleanupCounts{}, err
+	}
+
+	return counts, nil
+}
+
+// cleanupAuctions follows the same sequence as CleanupRegionRealmsAuctions, counting what was deleted
+func cleanupAuctions(sta fn.GatewayState, regionRealms sotah.RegionRealms) (cleanupCounts, error) {
+	endpoints, err := sta.IO.HellClient.GetActEndpoints()
+	if err != nil {
+		return cleanupCounts{}, err
+	}
+
+	actClient, err := act.NewClient(endpoints.CleanupAuctions)
+	if err != nil {
+		return cleanupCounts{}, err
+	}
+
+	startTime := time.Now()
+	counts := cleanupCounts{}
+	totalDeletedSizeBytes := int64(0)
+	for outJob := range actClient.CleanupAuctions(regionRealms) {
+		counts.tally("auctions", outJob.RegionRealmTuple, outJob.Data, outJob.Err, func(body string) (int, error) {
+			resp, err := sotah.NewCleanupAuctionsPayloadResponse(body)
+			totalDeletedSizeBytes += resp.TotalDeletedSizeBytes
+
+			return resp.TotalDeletedCount, err
+		})
+	}
+	counts.NoOp = counts.Deleted == 0
+
+	// reporting metrics
+	if err := sta.IO.BusClient.PublishMetrics(metric.Metrics{
+		"cleanup_all_auctions_duration":            int(time.Since(startTime) / time.Second),
+		"cleanup_all_auctions_total_deleted":       counts.Deleted,
+		"cleanup_all_auctions_total_deleted_bytes": int(totalDeletedSizeBytes),
+	}); err != nil {
+		return cleanupCounts{}, err
+	}
+
+	return counts, nil
+}
+
+// cleanupPricelistHistories follows the same sequence as CleanupRegionRealmsPricelistHistories, counting what was
+// deleted
+func cleanupPricelistHistories(sta fn.GatewayState, regionRealms sotah.RegionRealms) (cleanupCounts, error) {
+	endpoints, err := sta.IO.HellClient.GetActEndpoints()
+	if err != nil {
+		return cleanupCounts{}, err
+	}
+
+	actClient, err := act.NewClient(endpoints.CleanupPricelistHistories)
+	if err != nil {
+		return cleanupCounts{}, err
+	}
+
+	startTime := time.Now()
+	counts := cleanupCounts{}
+	for outJob := range actClient.CleanupPricelistHistories(regionRealms) {
+		counts.tally(
+			"pricelist_histories",
+			outJob.RegionRealmTuple,
+			outJob.Data,
+			outJob.Err,
+			func(body string) (int, error) {
+				resp, err := sotah.NewCleanupPricelistPayloadResponse(body)
+
+				return resp.TotalDeleted, err
+			},
+		)
+	}
+	counts.NoOp = counts.Deleted == 0
+
+	// reporting metrics
+	if err := sta.IO.BusClient.PublishMetrics(metric.Metrics{
+		"cleanup_all_pricelist_histories_duration":      int(time.Since(startTime) / time.Second),
+		"cleanup_all_pricelist_histories_total_deleted": counts.Deleted,
+	}); err != nil {
+		return cleanupCounts{}, err
+	}
+
+	return counts, nil
+}
+
+type cursoredRealm struct {
+	cursor string
+	realm  sotah.Realm
+}
+
+// sortedRealms flattens region-realms into a stable order, each realm keyed by its region/realm cursor
+func sortedRealms(regionRealms sotah.RegionRealms) []cursoredRealm {
+	out := []cursoredRealm{}
+	for regionName, realms := range regionRealms {
+		for _, realm := range realms {
+			out = append(out, cursoredRealm{cursor: fmt.Sprintf("%s/%s", regionName, realm.Slug), realm: realm})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].cursor < out[j].cursor
+	})
+
+	return out
+}
+
+// cleanupPricelistHistoriesFrom cleans up the pricelist-histories of every realm after the cursor in chunks,
+// stopping at the cleanup deadline with a cursor to resume from
+func cleanupPricelistHistoriesFrom(sta fn.GatewayState, cursor string) (cleanupResponse, error) {
+	startTime := time.Now()
+
+	regionRealms, err := getAllRegionRealms(sta)
+	if err != nil {
+		return cleanupResponse{}, err
+	}
+
+	remaining := []cursoredRealm{}
+	for _, realm := range sortedRealms(regionRealms) {
+		if realm.cursor > cursor {
+			remaining = append(remaining, realm)
+		}
+	}
+
+	out := cleanupResponse{}
+	for len(remaining) > 0 {
+		if cleanupDeadline > 0 && time.Since(startTime) > cleanupDeadline {
+			out.Resume = true
+			out.Cursor = cursor
+
+			break
+		}
+
+		chunk := remaining
+		if len(chunk) > cleanupChunkSize {
+			chunk = chunk[:cleanupChunkSize]
+		}
+		remaining = remaining[len(chunk):]
+
+		chunkRegionRealms := sotah.RegionRealms{}
+		for _, realm := range chunk {
+			chunkRegionRealms[realm.realm.Region.Name] = append(chunkRegionRealms[realm.realm.Region.Name], realm.realm)
+		}
+		counts, err := cleanupPricelistHistories(sta, chunkRegionRealms)
+		if err != nil {
+			return cleanupResponse{}, err
+		}
+
+		out.Realms += counts.Realms
+		out.Deleted += counts.Deleted
+		out.Failed += counts.Failed
+		cursor = chunk[len(chunk)-1].cursor
+	}
+	out.NoOp = out.Deleted == 0
+
+	return out, nil
+}
+
+type cleanupTarget struct {
+	name    string
+	cleanup func(sta fn.GatewayState, regionRealms sotah.RegionRealms) (cleanupCounts, error)
+
+	// note explains a target that is accepted but has nothing to clean up, in place of running it
+	note string
+}
+
+// cleanupTargets are in the order they are run, where manifests go last as they index the stored auctions
+var cleanupTargets = []cleanupTarget{
+	{name: "pricelist_histories", cleanup: cleanupPricelistHistories},
+	{name: "auctions", cleanup: cleanupAuctions},
+	{
+		name: "live_auctions",
+		note: "live auctions are overwritten on each compute and the act service has no cleanup for them",
+	},
+	{name: "manifests", cleanup: cleanupManifests},
+}
+
+type bulkCleanupRequest struct {
+	Targets []string `json:"targets"`
+}
+
+type bulkCleanupTargetResult struct {
+	Target string `json:"target"`
+	cleanupCounts
+	Note string `json:"note,omitempty"`
+}
+
+type bulkCleanupResponse struct {
+	DryRun  bool                      `json:"dry_run"`
+	Targets []bulkCleanupTargetResult `json:"targets"`
+	Error   string                    `json:"error,omitempty"`
+}
+
+// resolveCleanupTargets orders the requested targets into their run order, listing any that are unknown
+func resolveCleanupTargets(names []string) ([]cleanupTarget, []string) {
+	requested := map[string]bool{}
+	for _, name := range names {
+		requested[name] = true
+	}
+
+	out := []cleanupTarget{}
+	for _, target := range cleanupTargets {
+		if requested[target.name] {
+			out = append(out, target)
+			delete(requested, target.name)
+		}
+	}
+
+	unknown := []string{}
+	for name := range requested {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+
+	return out, unknown
+}
+
+func handleBulkCleanup(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	var req bulkCleanupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if !writeBodyReadError(w, err) {
+			writeErroneousResponse(w, http.StatusBadRequest, "Could not decode cleanup targets from request body")
+		}
+
+		return
+	}
+
+	targets, unknown := resolveCleanupTargets(req.Targets)
+	if len(unknown) > 0 {
+		writeErroneousResponse(
+			w,
+			http.StatusBadRequest,
+			fmt.Sprintf("Unknown cleanup targets: %s", strings.Join(unknown, ", ")),
+		)
+
+		return
+	}
+	if len(targets) == 0 {
+		writeErroneousResponse(w, http.StatusBadRequest, "No cleanup targets provided")
+
+		return
+	}
+
+	regionRealms, err := getAllRegionRealms(sta)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not gather realms")
+
+		logging.WithField("error", err.Error()).Error("Could not gather realms")
+
+		return
+	}
+
+	audit.Scope = req.Targets
+	audit.ScopeSize = regionRealms.TotalRealms()
+
+	resp := bulkCleanupResponse{
+		DryRun:  r.URL.Query().Get("dry_run") == "true",
+		Targets: []bulkCleanupTargetResult{},
+	}
+	for _, target := range targets {
+		result := bulkCleanupTargetResult{Target: target.name, Note: target.note}
+		switch {
+		case target.cleanup == nil:
+			// leaving targets with nothing to clean up at their note
+		case resp.DryRun:
+			result.Realms = regionRealms.TotalRealms()
+		default:
+			counts, err := target.cleanup(sta, regionRealms)
+			if err != nil {
+				if writeContextError(w, r, err) {
+					return
+				}
+
+				logging.WithFields(logrus.Fields{
+					"error":  err.Error(),
+					"target": target.name,
+				}).Error("Could not cleanup " + target.name)
+
+				// handing back the targets already cleaned up, as they are not undone
+				resp.Error = "Could not cleanup " + target.name
+				writeJSONResponse(w, http.StatusInternalServerError, resp)
+
+				return
+			}
+
+			result.cleanupCounts = counts
+		}
+
+		resp.Targets = append(resp.Targets, result)
+	}
+
+	writeJSONResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,220 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const computeLeaseCollection = "gateway_compute_leases"
+
+// computeLeasePollInterval is how often a coalesced request checks whether the lease holder has finished
+const computeLeasePollInterval = time.Second
+
+// computeLeaseTTL is how long an identical compute is coalesced onto the instance holding its lease,
+// where zero disables coalescing
+// the lease is held across instances, as each instance only ever serves a single request
+var computeLeaseTTL time.Duration
+
+func parseComputeLeaseTTL() (time.Duration, error) {
+	provided := os.Getenv("COMPUTE_LEASE_SECONDS")
+	if provided == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 0 {
+		return 0, errors.New("compute lease seconds must not be negative")
+	}
+
+	return time.Duration(parsed) * time.Second, nil
+}
+
+// computeLease is held by the instance computing a tuple set, and carries its outcome once done
+type computeLease struct {
+	ExpiresAt int64  `firestore:"expires_at"`
+	Done      bool   `firestore:"done"`
+	Result    string `firestore:"result"`
+	Error     string `firestore:"error"`
+}
+
+// tuplesKey hashes the normalized tuple set for a route, so that tuple ordering does not affect coalescing
+func tuplesKey(route string, tuples sotah.RegionRealmTimestampTuples) (string, error) {
+	normalized := make(sotah.RegionRealmTimestampTuples, len(tuples))
+	copy(normalized, tuples)
+	sort.Slice(normalized, func(i, j int) bool {
+		if normalized[i].RegionName != normalized[j].RegionName {
+			return normalized[i].RegionName < normalized[j].RegionName
+		}
+		if normalized[i].RealmSlug != normalized[j].RealmSlug {
+			return normalized[i].RealmSlug < normalized[j].RealmSlug
+		}
+
+		return normalized[i].TargetTimestamp < normalized[j].TargetTimestamp
+	})
+
+	jsonEncoded, err := json.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(append([]byte(route+":"), jsonEncoded...))
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func computeLeasePath(key string) string {
+	return fmt.Sprintf("%s/%s", computeLeaseCollection, key)
+}
+
+// coalesceCompute runs compute while holding the lease for the key, or waits on the outcome of the instance
+// already holding it
+// a lease that has expired or already finished is taken over, so only concurrent requests are coalesced
+func coalesceCompute(
+	ctx context.Context,
+	sta fn.GatewayState,
+	key string,
+	compute func() (sotah.RegionRealmTimestampTuples, error),
+) (sotah.RegionRealmTimestampTuples, bool, error) {
+	ref := sta.IO.HellClient.Doc(computeLeasePath(key))
+	shared := false
+
+	for {
+		// taking the lease when no instance holds one
+		held := computeLease{ExpiresAt: time.Now().Add(computeLeaseTTL).Unix()}
+		_, err := ref.Create(sta.IO.HellClient.Context, held)
+		if err == nil {
+			stopRenewing := renewComputeLease(sta, ref)
+			out, err := compute()
+			stopRenewing()
+			finishComputeLease(sta, ref, out, err)
+
+			return out, shared, err
+		}
+		if status.Code(err) != codes.AlreadyExists {
+			return sotah.RegionRealmTimestampTuples{}, shared, err
+		}
+
+		docsnap, err := ref.Get(sta.IO.HellClient.Context)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+
+			return sotah.RegionRealmTimestampTuples{}, shared, err
+		}
+
+		var lease computeLease
+		if err := docsnap.DataTo(&lease); err != nil {
+			return sotah.RegionRealmTimestampTuples{}, shared, err
+		}
+
+		// handing back the outcome of the instance this request was waiting on
+		if lease.Done && shared {
+			if lease.Error != "" {
+				return sotah.RegionRealmTimestampTuples{}, shared, errors.New(lease.Error)
+			}
+
+			var out sotah.RegionRealmTimestampTuples
+			if err := json.Unmarshal([]byte(lease.Result), &out); err != nil {
+				return sotah.RegionRealmTimestampTuples{}, shared, err
+			}
+
+			return out, shared, nil
+		}
+
+		// taking over a lease that finished before this request arrived, or whose holder has gone away
+		// the delete is conditional, so that only one of several instances taking over goes on to create it
+		if lease.Done || time.Now().Unix() > lease.ExpiresAt {
+			if _, err := ref.Delete(sta.IO.HellClient.Context, firestore.LastUpdateTime(docsnap.UpdateTime)); err != nil {
+				if status.Code(err) != codes.FailedPrecondition {
+					return sotah.RegionRealmTimestampTuples{}, shared, err
+				}
+			}
+
+			continue
+		}
+
+		// waiting on the instance holding the lease
+		shared = true
+		select {
+		case <-ctx.Done():
+			return sotah.RegionRealmTimestampTuples{}, shared, ctx.Err()
+		case <-time.After(computeLeasePollInterval):
+		}
+	}
+}
+
+// renewComputeLease extends the lease on a ticker while its holder computes, so that a compute running longer than
+// the lease ttl is not taken over and run again by a waiting instance
+// the returned func stops renewing, and returns once no renewal is in flight
+func renewComputeLease(sta fn.GatewayState, ref *firestore.DocumentRef) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(computeLeaseTTL / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_, err := ref.Update(sta.IO.HellClient.Context, []firestore.Update{
+					{Path: "expires_at", Value: time.Now().Add(computeLeaseTTL).Unix()},
+				})
+				if err != nil {
+					logging.WithField("error", err.Error()).Error("Failed to renew compute lease")
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// finishComputeLease records the outcome on the lease, so that coalesced requests can hand it back
+func finishComputeLease(
+	sta fn.GatewayState,
+	ref *firestore.DocumentRef,
+	out sotah.RegionRealmTimestampTuples,
+	computeErr error,
+) {
+	lease := computeLease{ExpiresAt: time.Now().Add(computeLeaseTTL).Unix(), Done: true}
+	if computeErr != nil {
+		lease.Error = computeErr.Error()
+	} else {
+		jsonEncoded, err := json.Marshal(out)
+		if err != nil {
+			lease.Error = err.Error()
+		} else {
+			lease.Result = string(jsonEncoded)
+		}
+	}
+
+	if _, err := ref.Set(sta.IO.HellClient.Context, lease); err != nil {
+		logging.WithField("error", err.Error()).Error("Failed to finish compute lease")
+	}
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+)
+
+func TestTuplesKey(t *testing.T) {
+	tuples := sotah.RegionRealmTimestampTuples{
+		newTestTuple("us", "zuljin", 1),
+		newTestTuple("eu", "silvermoon", 2),
+		newTestTuple("us", "aegwynn", 1),
+		newTestTuple("us", "aegwynn", 0),
+	}
+	reordered := sotah.RegionRealmTimestampTuples{tuples[2], tuples[0], tuples[3], tuples[1]}
+
+	tests := []struct {
+		name      string
+		route     string
+		tuples    sotah.RegionRealmTimestampTuples
+		otherKey  string
+		otherSet  sotah.RegionRealmTimestampTuples
+		wantEqual bool
+	}{
+		{
+			name:      "ordering does not matter",
+			route:     "/compute-all-live-auctions",
+			tuples:    tuples,
+			otherKey:  "/compute-all-live-auctions",
+			otherSet:  reordered,
+			wantEqual: true,
+		},
+		{
+			name:      "route matters",
+			route:     "/compute-all-live-auctions",
+			tuples:    tuples,
+			otherKey:  "/compute-all-pricelist-histories",
+			otherSet:  tuples,
+			wantEqual: false,
+		},
+		{
+			name:      "timestamp matters",
+			route:     "/compute-all-live-auctions",
+			tuples:    sotah.RegionRealmTimestampTuples{newTestTuple("us", "aegwynn", 1)},
+			otherKey:  "/compute-all-live-auctions",
+			otherSet:  sotah.RegionRealmTimestampTuples{newTestTuple("us", "aegwynn", 2)},
+			wantEqual: false,
+		},
+		{
+			name:      "region matters",
+			route:     "/compute-all-live-auctions",
+			tuples:    sotah.RegionRealmTimestampTuples{newTestTuple("us", "aegwynn", 1)},
+			otherKey:  "/compute-all-live-auctions",
+			otherSet:  sotah.RegionRealmTimestampTuples{newTestTuple("eu", "aegwynn", 1)},
+			wantEqual: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := tuplesKey(test.route, test.tuples)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			otherKey, err := tuplesKey(test.otherKey, test.otherSet)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if (key == otherKey) != test.wantEqual {
+				t.Errorf("got keys %s and %s, want equal to be %t", key, otherKey, test.wantEqual)
+			}
+		})
+	}
+}
+
+func TestTuplesKeyLeavesTuplesUnsorted(t *testing.T) {
+	tuples := sotah.RegionRealmTimestampTuples{newTestTuple("us", "zuljin", 1), newTestTuple("us", "aegwynn", 1)}
+	if _, err := tuplesKey("/compute-all-live-auctions", tuples); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if tuples[0].RealmSlug != "zuljin" {
+		t.Errorf("tuples were sorted in place")
+	}
+}
@@ -0,0 +1,634 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/hell"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/metric"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+)
+
+// computeFunc computes the given tuples, handing back those that were computed and reporting each as it completes
+type computeFunc func(
+	sta fn.GatewayState,
+	tuples sotah.RegionRealmTimestampTuples,
+	progress progressFunc,
+) (sotah.RegionRealmTimestampTuples, error)
+
+// computeTarget describes what a compute route computes, so that both compute routes share one handler
+type computeTarget struct {
+	name     string
+	compute  computeFunc
+	computed func(hellRealm hell.Realm) int
+	coalesce bool
+}
+
+var liveAuctionsTarget = computeTarget{
+	name:    "compute-all-live-auctions",
+	compute: computeLiveAuctions,
+	computed: func(hellRealm hell.Realm) int {
+		return hellRealm.LiveAuctionsReceived
+	},
+	coalesce: true,
+}
+
+var pricelistHistoriesTarget = computeTarget{
+	name:    "compute-all-pricelist-histories",
+	compute: computePricelistHistories,
+	computed: func(hellRealm hell.Realm) int {
+		return hellRealm.PricelistHistoriesReceived
+	},
+}
+
+// computeLiveAuctions follows the same sequence as ComputeAllLiveAuctions, calling the act service itself so that
+// each tuple is known to be computed as soon as its call completes
+func computeLiveAuctions(
+	sta fn.GatewayState,
+	tuples sotah.RegionRealmTimestampTuples,
+	progress progressFunc,
+) (sotah.RegionRealmTimestampTuples, error) {
+	startTime := time.Now()
+	jobs, err := callActCompute(sta, "/compute-live-auctions", liveAuctionsComputeWorkers, tuples)
+	if err != nil {
+		return sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	summaryTuples := sotah.RegionRealmSummaryTuples{}
+	computed := sotah.RegionRealmTimestampTuples{}
+	for job := range jobs {
+		body, ok := acceptedActComputeBody(job, progress)
+		if !ok {
+			continue
+		}
+
+		summaryTuple, err := sotah.NewRegionRealmSummaryTuple(string(body))
+		if err != nil {
+			logging.WithFields(logrus.Fields{
+				"error":  err.Error(),
+				"region": job.tuple.RegionName,
+				"realm":  job.tuple.RealmSlug,
+			}).Error("Failed to decode region-realm-summary tuple from act response body")
+			progress.failed(job.tuple, failureDecodeFailed)
+
+			continue
+		}
+
+		summaryTuples = append(summaryTuples, summaryTuple)
+		computed = append(computed, job.tuple)
+		progress.succeeded(job.tuple)
+	}
+
+	// reporting metrics
+	if err := sta.IO.BusClient.PublishMetrics(metric.Metrics{
+		"compute_all_live_auctions_duration":     int(time.Since(startTime) / time.Second),
+		"included_realms_computed_live_auctions": len(tuples),
+	}); err != nil {
+		return sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	// optionally halting on no results
+	if len(summaryTuples) == 0 {
+		return sotah.RegionRealmTimestampTuples{}, nil
+	}
+
+	if err := sta.PublishComputedLiveAuctions(summaryTuples.RegionRealmTuples()); err != nil {
+		return sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	if err := sta.PublishToCallSyncAllItems(summaryTuples.ItemIds()); err != nil {
+		return sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	return computed, nil
+}
+
+// computePricelistHistories follows the same sequence as ComputeAllPricelistHistories, calling the act service
+// itself so that each tuple is known to be computed as soon as its call completes
+func computePricelistHistories(
+	sta fn.GatewayState,
+	tuples sotah.RegionRealmTimestampTuples,
+	progress progressFunc,
+) (sotah.RegionRealmTimestampTuples, error) {
+	startTime := time.Now()
+	jobs, err := callActCompute(sta, "/compute-pricelist-histories", pricelistHistoriesComputeWorkers, tuples)
+	if err != nil {
+		return sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	// the requested tuples are handed back for attributing outcomes, while the tuples the act service responded
+	// with are what gets published
+	computed := sotah.RegionRealmTimestampTuples{}
+	computedTuples := sotah.RegionRealmTimestampTuples{}
+	for job := range jobs {
+		body, ok := acceptedActComputeBody(job, progress)
+		if !ok {
+			continue
+		}
+
+		computedTuple, err := sotah.NewRegionRealmTimestampTuple(string(body))
+		if err != nil {
+			logging.WithFields(logrus.Fields{
+				"error":  err.Error(),
+				"region": job.tuple.RegionName,
+				"realm":  job.tuple.RealmSlug,
+			}).Error("Failed to decode region-realm-timestamp tuple from act response body")
+			progress.failed(job.tuple, failureDecodeFailed)
+
+			continue
+		}
+
+		computed = append(computed, job.tuple)
+		computedTuples = append(computedTuples, computedTuple)
+		progress.succeeded(job.tuple)
+	}
+
+	// reporting metrics
+	if err := sta.IO.BusClient.PublishMetrics(metric.Metrics{
+		"compute_all_pricelist_histories_duration":     int(time.Since(startTime) / time.Second),
+		"included_realms_computed_pricelist_histories": len(tuples),
+	}); err != nil {
+		return sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	// optionally halting on no results
+	if len(computed) == 0 {
+		return sotah.RegionRealmTimestampTuples{}, nil
+	}
+
+	if err := sta.PublishComputedPricelistHistories(computedTuples); err != nil {
+		return sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	return computed, nil
+}
+
+// splitComputedTuples separates the requested tuples that were computed from those that were not
+func splitComputedTuples(
+	tuples sotah.RegionRealmTimestampTuples,
+	computed sotah.RegionRealmTimestampTuples,
+) (sotah.RegionRealmTimestampTuples, sotah.RegionRealmTimestampTuples) {
+	wasComputed := map[sotah.RegionRealmTimestampTuple]struct{}{}
+	for _, tuple := range computed {
+		wasComputed[tuple] = struct{}{}
+	}
+
+	succeeded := sotah.RegionRealmTimestampTuples{}
+	failed := sotah.RegionRealmTimestampTuples{}
+	for _, tuple := range tuples {
+		if _, ok := wasComputed[tuple]; ok {
+			succeeded = append(succeeded, tuple)
+
+			continue
+		}
+
+		failed = append(failed, tuple)
+	}
+
+	return succeeded, failed
+}
+
+// partialSuccessStatus is the status of compute responses where only some tuples were computed, and of checkpointed
+// downloads where some realms failed
+var partialSuccessStatus = http.StatusOK
+
+func parsePartialSuccessStatus() (int, error) {
+	switch os.Getenv("PARTIAL_SUCCESS_STATUS") {
+	case "", "200":
+		return http.StatusOK, nil
+	case "207":
+		return http.StatusMultiStatus, nil
+	default:
+		return 0, errors.New("partial success status must be one of 200 or 207")
+	}
+}
+
+type computeResponse struct {
+	DryRun           bool                             `json:"dry_run"`
+	Tuples           int                              `json:"tuples"`
+	SkippedUnchanged sotah.RegionRealmTimestampTuples `json:"skipped_unchanged"`
+	SkippedNotNewer  sotah.RegionRealmTimestampTuples `json:"skipped_not_newer"`
+	Deduplicated     int                              `json:"deduplicated"`
+	Timing           []phaseTiming                    `json:"timing,omitempty"`
+	Computed         int                              `json:"computed"`
+	NoOp             bool                             `json:"no_op"`
+	Succeeded        sotah.RegionRealmTimestampTuples `json:"succeeded"`
+	Failed           sotah.RegionRealmTimestampTuples `json:"failed"`
+	Failures         []tupleFailure                   `json:"failures"`
+
+	// Regions regroups the per-tuple results by region and realm when requested with group_by=region
+	Regions map[string]map[string][]tupleResult `json:"regions,omitempty"`
+}
+
+type tupleResult struct {
+	TargetTimestamp int    `json:"target_timestamp"`
+	Outcome         string `json:"outcome"`
+}
+
+// groupComputeResults regroups the per-tuple results of a compute response by region and realm, where pending
+// tuples are those a dry run would have computed
+// a realm lists a result per timestamp in timestamp order, as a batch may hold several timestamps for one realm
+func groupComputeResults(
+	resp computeResponse,
+	pending sotah.RegionRealmTimestampTuples,
+) map[string]map[string][]tupleResult {
+	out := map[string]map[string][]tupleResult{}
+	add := func(tuples sotah.RegionRealmTimestampTuples, outcome string) {
+		for _, tuple := range tuples {
+			if _, ok := out[tuple.RegionName]; !ok {
+				out[tuple.RegionName] = map[string][]tupleResult{}
+			}
+
+			out[tuple.RegionName][tuple.RealmSlug] = append(out[tuple.RegionName][tuple.RealmSlug], tupleResult{
+				TargetTimestamp: tuple.TargetTimestamp,
+				Outcome:         outcome,
+			})
+		}
+	}
+
+	add(resp.SkippedUnchanged, "skipped_unchanged")
+	add(resp.SkippedNotNewer, "skipped_not_newer")
+	add(pending, "pending")
+	add(resp.Succeeded, "succeeded")
+	add(resp.Failed, "failed")
+
+	for _, realms := range out {
+		for _, results := range realms {
+			sort.Slice(results, func(i, j int) bool {
+				return results[i].TargetTimestamp < results[j].TargetTimestamp
+			})
+		}
+	}
+
+	return out
+}
+
+type noTuplesResponse struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+type emptyComputeResponse struct {
+	Processed int `json:"processed"`
+}
+
+type duplicateTuplesResponse struct {
+	Error      string                           `json:"error"`
+	Duplicates sotah.RegionRealmTimestampTuples `json:"duplicates"`
+}
+
+type mismatchedTuplesResponse struct {
+	Error      string            `json:"error"`
+	Mismatched []mismatchedTuple `json:"mismatched"`
+}
+
+type unresolvedTuplesResponse struct {
+	Error      string                           `json:"error"`
+	Unresolved sotah.RegionRealmTimestampTuples `json:"unresolved"`
+}
+
+type missingManifestTuplesResponse struct {
+	Error   string                           `json:"error"`
+	Missing sotah.RegionRealmTimestampTuples `json:"missing"`
+}
+
+type futureTuplesResponse struct {
+	Error  string                           `json:"error"`
+	Future sotah.RegionRealmTimestampTuples `json:"future"`
+}
+
+func handleComputeAllLiveAuctions(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	serveCompute(w, r, sta, audit, liveAuctionsTarget)
+}
+
+func handleComputeAllPricelistHistories(
+	w http.ResponseWriter,
+	r *http.Request,
+	sta fn.GatewayState,
+	audit *auditEntry,
+) {
+	serveCompute(w, r, sta, audit, pricelistHistoriesTarget)
+}
+
+func serveCompute(
+	w http.ResponseWriter,
+	r *http.Request,
+	sta fn.GatewayState,
+	audit *auditEntry,
+	target computeTarget,
+) {
+	timer := newPhaseTimer()
+
+	groupByRegion := false
+	switch r.URL.Query().Get("group_by") {
+	case "":
+	case "region":
+		groupByRegion = true
+	default:
+		writeErroneousResponse(w, http.StatusBadRequest, "Results may only be grouped by region")
+
+		return
+	}
+
+	ifNewerThan, conditional, err := parseIfNewerThan(r.Header)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusBadRequest, "Could not parse If-Newer-Than header")
+
+		return
+	}
+
+	req, err := decodeComputeRequest(r.Body, r.URL.Query())
+	if err != nil {
+		writeTuplesDecodeError(w, err)
+
+		return
+	}
+	timer.mark("decode")
+
+	// answering empty batches explicitly, as either an error or a no-op
+	if len(req.Tuples) == 0 {
+		if req.Options.AllowEmpty {
+			writeJSONResponse(w, http.StatusOK, emptyComputeResponse{Processed: 0})
+
+			return
+		}
+
+		writeJSONResponse(w, http.StatusBadRequest, noTuplesResponse{
+			Code:  "no_tuples",
+			Error: "Request body contains no region-realm-timestamp tuples",
+		})
+
+		return
+	}
+
+	// optionally resolving omitted timestamps to each realm's latest download
+	if req.Options.UseLatest {
+		resolved, unresolved, err := resolveLatestTuples(sta, req.Tuples)
+		if err != nil {
+			writeErroneousResponse(w, http.StatusInternalServerError, "Could not resolve latest timestamps")
+
+			logging.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Could not resolve latest timestamps")
+
+			return
+		}
+		if len(unresolved) > 0 {
+			writeJSONResponse(w, http.StatusUnprocessableEntity, unresolvedTuplesResponse{
+				Error:      "Request body contains region-realm tuples with no downloaded timestamp to resolve",
+				Unresolved: unresolved,
+			})
+
+			return
+		}
+
+		req.Tuples = resolved
+	}
+
+	tuples, duplicates := splitDuplicateTuples(req.Tuples)
+
+	// rejecting duplicate tuples unless they are to be deduplicated
+	if len(duplicates) > 0 && !req.Options.Dedupe {
+		writeJSONResponse(w, http.StatusBadRequest, duplicateTuplesResponse{
+			Error:      "Request body contains duplicate region-realm-timestamp tuples",
+			Duplicates: duplicates,
+		})
+
+		return
+	}
+
+	// rejecting tuples timestamped in the future, or only warning on them when lenient
+	if future := futureTuples(tuples, time.Now()); len(future) > 0 {
+		if !req.Options.Lenient {
+			writeJSONResponse(w, http.StatusUnprocessableEntity, futureTuplesResponse{
+				Error:  "Request body contains region-realm-timestamp tuples with future timestamps",
+				Future: future,
+			})
+
+			return
+		}
+
+		logging.WithFields(logrus.Fields{
+			"target": target.name,
+			"future": future,
+		}).Warn("Received region-realm-timestamp tuples with future timestamps")
+	}
+
+	// rejecting tuples whose realm does not belong to their region
+	mismatched, err := mismatchedTuples(sta, tuples)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not check tuples against realms")
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not check tuples against realms")
+
+		return
+	}
+	if len(mismatched) > 0 {
+		writeJSONResponse(w, http.StatusUnprocessableEntity, mismatchedTuplesResponse{
+			Error:      "Request body contains region-realm-timestamp tuples with realms outside their region",
+			Mismatched: mismatched,
+		})
+
+		return
+	}
+
+	// rejecting tuples with no stored manifest within tolerance, as there is nothing to compute them from
+	tuples, missing, err := matchManifestTuples(sta, tuples)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not check tuples for stored manifests")
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not check tuples for stored manifests")
+
+		return
+	}
+	if len(missing) > 0 {
+		writeJSONResponse(w, http.StatusUnprocessableEntity, missingManifestTuplesResponse{
+			Error:   "Request body contains region-realm-timestamp tuples with no stored manifest within tolerance",
+			Missing: missing,
+		})
+
+		return
+	}
+
+	audit.setTupleScope(tuples)
+	timer.mark("validate")
+
+	resp := computeResponse{
+		DryRun:           req.Options.DryRun,
+		SkippedUnchanged: sotah.RegionRealmTimestampTuples{},
+		SkippedNotNewer:  sotah.RegionRealmTimestampTuples{},
+		Deduplicated:     len(duplicates),
+		Succeeded:        sotah.RegionRealmTimestampTuples{},
+		Failed:           sotah.RegionRealmTimestampTuples{},
+		Failures:         []tupleFailure{},
+	}
+
+	// optionally skipping tuples that have already been computed
+	if req.Options.SkipUnchanged {
+		tuples, resp.SkippedUnchanged, err = filterUnchangedTuples(sta, tuples, target.computed)
+		if err != nil {
+			writeErroneousResponse(w, http.StatusInternalServerError, "Could not check tuples for changes")
+
+			logging.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Could not check tuples for changes")
+
+			return
+		}
+
+		logging.WithFields(logrus.Fields{
+			"target":            target.name,
+			"tuples":            len(tuples),
+			"skipped-unchanged": len(resp.SkippedUnchanged),
+		}).Info("Filtered unchanged tuples")
+		timer.mark("filter-unchanged")
+	}
+
+	// optionally skipping tuples whose source data is not newer than the client already has
+	if conditional {
+		tuples, resp.SkippedNotNewer = splitNotNewerTuples(tuples, ifNewerThan)
+	}
+	resp.Tuples = len(tuples)
+	resp.NoOp = resp.Tuples == 0
+
+	// optionally halting ahead of computing
+	if req.Options.DryRun || ((req.Options.SkipUnchanged || conditional) && len(tuples) == 0) {
+		if req.Options.Timing {
+			resp.Timing = timer.phases
+		}
+		if groupByRegion {
+			resp.Regions = groupComputeResults(resp, tuples)
+		}
+
+		writeJSONResponse(w, http.StatusOK, resp)
+
+		return
+	}
+
+	// optionally streaming each tuple's outcome as it completes, ending with the response as the last line
+	var stream *progressStream
+	if acceptsProgressStream(r) {
+		stream = newProgressStream(w)
+	}
+
+	progress, failures := collectFailures(stream.progress())
+	computed, err := callCompute(r, sta, target, tuples, progress)
+	timer.mark("compute")
+	if err != nil {
+		recordComputeOutcomes(sta, target, sotah.RegionRealmTimestampTuples{}, tuples, err.Error())
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not call " + target.name)
+
+		if stream != nil {
+			stream.write(progressError{Error: "Could not call " + target.name})
+
+			return
+		}
+
+		if writeContextError(w, r, err) {
+			return
+		}
+
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not call "+target.name)
+
+		return
+	}
+	resp.Succeeded, resp.Failed = splitComputedTuples(tuples, computed)
+	resp.Computed = len(resp.Succeeded)
+	resp.NoOp = resp.Computed == 0
+	resp.Failures = tupleFailures(resp.Failed, failures)
+	recordComputeOutcomes(sta, target, resp.Succeeded, sotah.RegionRealmTimestampTuples{}, "")
+	for reason, failed := range failedByReason(resp.Failures) {
+		recordComputeOutcomes(sta, target, sotah.RegionRealmTimestampTuples{}, failed, string(reason))
+	}
+	timer.mark("record-outcomes")
+
+	if req.Options.Timing {
+		resp.Timing = timer.phases
+	}
+	if groupByRegion {
+		resp.Regions = groupComputeResults(resp, sotah.RegionRealmTimestampTuples{})
+	}
+
+	if stream != nil {
+		stream.write(resp)
+
+		return
+	}
+
+	// mapping the per-tuple outcomes onto the response status
+	switch {
+	case len(resp.Failed) == 0:
+		writeJSONResponse(w, http.StatusCreated, resp)
+	case len(resp.Succeeded) == 0:
+		writeJSONResponse(w, http.StatusInternalServerError, resp)
+	default:
+		writeJSONResponse(w, partialSuccessStatus, resp)
+	}
+}
+
+// writeTuplesDecodeError reports where decoding failed when the failure is within a tuple
+func writeTuplesDecodeError(w http.ResponseWriter, err error) {
+	logging.WithFields(logrus.Fields{
+		"error": err.Error(),
+	}).Error("Could not decode region-realm-timestamp tuples from request body")
+
+	if writeBodyReadError(w, err) {
+		return
+	}
+
+	if decodeErr, ok := err.(tupleDecodeError); ok {
+		writeJSONResponse(w, http.StatusBadRequest, decodeErr)
+
+		return
+	}
+
+	writeErroneousResponse(
+		w,
+		http.StatusBadRequest,
+		"Could not decode region-realm-timestamp tuples from request body",
+	)
+}
+
+func callCompute(
+	r *http.Request,
+	sta fn.GatewayState,
+	target computeTarget,
+	tuples sotah.RegionRealmTimestampTuples,
+	progress progressFunc,
+) (sotah.RegionRealmTimestampTuples, error) {
+	if !target.coalesce || computeLeaseTTL == 0 {
+		return target.compute(sta, tuples, progress)
+	}
+
+	key, err := tuplesKey(r.URL.Path, tuples)
+	if err != nil {
+		return sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	// attaching to an identical in-flight compute on another instance when there is one
+	// progress is only reported by the instance holding the lease
+	computed, shared, err := coalesceCompute(r.Context(), sta, key, func() (sotah.RegionRealmTimestampTuples, error) {
+		return target.compute(sta, tuples, progress)
+	})
+	if shared {
+		logging.WithField("tuples", len(tuples)).Info("Coalesced identical " + target.name + " requests")
+	}
+	if err != nil {
+		return sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	return computed, nil
+}
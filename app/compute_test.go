@@ -0,0 +1,122 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+)
+
+func TestSplitComputedTuples(t *testing.T) {
+	tests := []struct {
+		name          string
+		tuples        sotah.RegionRealmTimestampTuples
+		computed      sotah.RegionRealmTimestampTuples
+		wantSucceeded sotah.RegionRealmTimestampTuples
+		wantFailed    sotah.RegionRealmTimestampTuples
+	}{
+		{
+			name:          "all computed",
+			tuples:        sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1), newTestTuple("us", "b", 1)},
+			computed:      sotah.RegionRealmTimestampTuples{newTestTuple("us", "b", 1), newTestTuple("us", "a", 1)},
+			wantSucceeded: sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1), newTestTuple("us", "b", 1)},
+			wantFailed:    sotah.RegionRealmTimestampTuples{},
+		},
+		{
+			name:          "none computed",
+			tuples:        sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1)},
+			computed:      sotah.RegionRealmTimestampTuples{},
+			wantSucceeded: sotah.RegionRealmTimestampTuples{},
+			wantFailed:    sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1)},
+		},
+		{
+			name:          "computed at another timestamp",
+			tuples:        sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1), newTestTuple("us", "b", 1)},
+			computed:      sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 2), newTestTuple("us", "b", 1)},
+			wantSucceeded: sotah.RegionRealmTimestampTuples{newTestTuple("us", "b", 1)},
+			wantFailed:    sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			succeeded, failed := splitComputedTuples(test.tuples, test.computed)
+			if !reflect.DeepEqual(succeeded, test.wantSucceeded) {
+				t.Errorf("got succeeded %+v, want %+v", succeeded, test.wantSucceeded)
+			}
+			if !reflect.DeepEqual(failed, test.wantFailed) {
+				t.Errorf("got failed %+v, want %+v", failed, test.wantFailed)
+			}
+		})
+	}
+}
+
+func TestGroupComputeResults(t *testing.T) {
+	tests := []struct {
+		name    string
+		resp    computeResponse
+		pending sotah.RegionRealmTimestampTuples
+		want    map[string]map[string][]tupleResult
+	}{
+		{
+			name:    "empty",
+			resp:    computeResponse{},
+			pending: sotah.RegionRealmTimestampTuples{},
+			want:    map[string]map[string][]tupleResult{},
+		},
+		{
+			name: "grouped by region and realm",
+			resp: computeResponse{
+				Succeeded: sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1), newTestTuple("eu", "a", 1)},
+				Failed:    sotah.RegionRealmTimestampTuples{newTestTuple("us", "b", 1)},
+			},
+			pending: sotah.RegionRealmTimestampTuples{},
+			want: map[string]map[string][]tupleResult{
+				"us": {
+					"a": {{TargetTimestamp: 1, Outcome: "succeeded"}},
+					"b": {{TargetTimestamp: 1, Outcome: "failed"}},
+				},
+				"eu": {
+					"a": {{TargetTimestamp: 1, Outcome: "succeeded"}},
+				},
+			},
+		},
+		{
+			name: "several timestamps of a realm in timestamp order",
+			resp: computeResponse{
+				SkippedUnchanged: sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 3)},
+				SkippedNotNewer:  sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1)},
+				Succeeded:        sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 2)},
+			},
+			pending: sotah.RegionRealmTimestampTuples{},
+			want: map[string]map[string][]tupleResult{
+				"us": {
+					"a": {
+						{TargetTimestamp: 1, Outcome: "skipped_not_newer"},
+						{TargetTimestamp: 2, Outcome: "succeeded"},
+						{TargetTimestamp: 3, Outcome: "skipped_unchanged"},
+					},
+				},
+			},
+		},
+		{
+			name:    "pending on a dry run",
+			resp:    computeResponse{DryRun: true},
+			pending: sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1)},
+			want: map[string]map[string][]tupleResult{
+				"us": {
+					"a": {{TargetTimestamp: 1, Outcome: "pending"}},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := groupComputeResults(test.resp, test.pending)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah/gameversions"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const computeOutcomesCollection = "gateway_compute_outcomes"
+
+// computeOutcome is the gateway's record of the last compute call it made for a realm
+type computeOutcome struct {
+	TargetTimestamp int    `firestore:"target_timestamp" json:"target_timestamp"`
+	Succeeded       bool   `firestore:"succeeded" json:"succeeded"`
+	Error           string `firestore:"error" json:"error,omitempty"`
+	RecordedAt      int64  `firestore:"recorded_at" json:"recorded_at"`
+}
+
+func computeOutcomePath(targetName string, tuple sotah.RegionRealmTimestampTuple) string {
+	return fmt.Sprintf("%s/%s-%s-%s", computeOutcomesCollection, targetName, tuple.RegionName, tuple.RealmSlug)
+}
+
+// recordComputeOutcomes persists the outcome of a compute call against each of its tuples
+func recordComputeOutcomes(
+	sta fn.GatewayState,
+	target computeTarget,
+	succeeded sotah.RegionRealmTimestampTuples,
+	failed sotah.RegionRealmTimestampTuples,
+	failure string,
+) {
+	recordedAt := time.Now().Unix()
+	record := func(tuple sotah.RegionRealmTimestampTuple, outcome computeOutcome) {
+		if _, err := sta.IO.HellClient.Doc(computeOutcomePath(target.name, tuple)).Set(
+			sta.IO.HellClient.Context,
+			outcome,
+		); err != nil {
+			logging.WithFields(logrus.Fields{
+				"error":  err.Error(),
+				"target": target.name,
+				"region": tuple.RegionName,
+				"realm":  tuple.RealmSlug,
+			}).Error("Failed to record compute outcome")
+		}
+	}
+
+	for _, tuple := range succeeded {
+		record(tuple, computeOutcome{
+			TargetTimestamp: tuple.TargetTimestamp,
+			Succeeded:       true,
+			RecordedAt:      recordedAt,
+		})
+	}
+	for _, tuple := range failed {
+		record(tuple, computeOutcome{
+			TargetTimestamp: tuple.TargetTimestamp,
+			Error:           failure,
+			RecordedAt:      recordedAt,
+		})
+	}
+}
+
+type tupleComputeStatus struct {
+	sotah.RegionRealmTimestampTuple
+	LiveAuctionsReceived       int                       `json:"live_auctions_received"`
+	PricelistHistoriesReceived int                       `json:"pricelist_histories_received"`
+	Outcomes                   map[string]computeOutcome `json:"outcomes"`
+}
+
+// getComputeStatuses reports, per tuple, the last received computes from hell alongside the last recorded outcomes
+func getComputeStatuses(
+	sta fn.GatewayState,
+	tuples sotah.RegionRealmTimestampTuples,
+) ([]tupleComputeStatus, error) {
+	hellRegionRealms, err := sta.IO.HellClient.GetRegionRealms(tuples.ToRegionRealmSlugs(), gameversions.Retail)
+	if err != nil {
+		return []tupleComputeStatus{}, err
+	}
+
+	out := []tupleComputeStatus{}
+	for _, tuple := range tuples {
+		hellRealm := hellRegionRealms[blizzard.RegionName(tuple.RegionName)][blizzard.RealmSlug(tuple.RealmSlug)]
+		result := tupleComputeStatus{
+			RegionRealmTimestampTuple:  tuple,
+			LiveAuctionsReceived:       hellRealm.LiveAuctionsReceived,
+			PricelistHistoriesReceived: hellRealm.PricelistHistoriesReceived,
+			Outcomes:                   map[string]computeOutcome{},
+		}
+
+		for _, target := range []computeTarget{liveAuctionsTarget, pricelistHistoriesTarget} {
+			docsnap, err := sta.IO.HellClient.Doc(computeOutcomePath(target.name, tuple)).Get(sta.IO.HellClient.Context)
+			if err != nil {
+				if status.Code(err) == codes.NotFound {
+					continue
+				}
+
+				return []tupleComputeStatus{}, err
+			}
+
+			var outcome computeOutcome
+			if err := docsnap.DataTo(&outcome); err != nil {
+				return []tupleComputeStatus{}, err
+			}
+
+			result.Outcomes[target.name] = outcome
+		}
+
+		out = append(out, result)
+	}
+
+	return out, nil
+}
+
+func handleComputeStatus(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	req, err := decodeComputeRequest(r.Body, r.URL.Query())
+	if err != nil {
+		writeTuplesDecodeError(w, err)
+
+		return
+	}
+
+	statuses, err := getComputeStatuses(sta, req.Tuples)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not gather compute statuses")
+
+		logging.WithField("error", err.Error()).Error("Could not gather compute statuses")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, statuses)
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+)
+
+// effectiveConfig is the configuration the instance is running with, leaving out secrets
+type effectiveConfig struct {
+	ProjectId                 string           `json:"project_id"`
+	ReadOnly                  bool             `json:"read_only"`
+	AutoWarmup                bool             `json:"auto_warmup"`
+	DefaultRegion             string           `json:"default_region"`
+	StorageRegion             string           `json:"storage_region"`
+	LogSampleRate             float64          `json:"log_sample_rate"`
+	ProblemResponses          bool             `json:"problem_responses"`
+	PartialSuccessStatus      int              `json:"partial_success_status"`
+	MaxConcurrency            int              `json:"max_concurrency"`
+	QueueDepth                int              `json:"queue_depth"`
+	QueueMaxWaitSeconds       int64            `json:"queue_max_wait_seconds"`
+	MaxBodyBytes              int64            `json:"max_body_bytes"`
+	RouteMaxBodyBytes         map[string]int64 `json:"route_max_body_bytes"`
+	BodyReadTimeoutSeconds    int64            `json:"body_read_timeout_seconds"`
+	ReadDeadlineSeconds       int64            `json:"read_deadline_seconds"`
+	CleanupDeadlineSeconds    int64            `json:"cleanup_deadline_seconds"`
+	TimestampSkewSeconds      int64            `json:"timestamp_skew_seconds"`
+	FreshnessThresholdSeconds int64            `json:"freshness_threshold_seconds"`
+	SyncMaxItems              int              `json:"sync_max_items"`
+	SyncDedupTTLSeconds       int64            `json:"sync_dedup_ttl_seconds"`
+	SyncBlocklistSize         int              `json:"sync_blocklist_size"`
+	ComputeLeaseSeconds       int64            `json:"compute_lease_seconds"`
+	ManifestToleranceSeconds  int64            `json:"manifest_match_tolerance_seconds"`
+	CacheMaxAges              map[string]int   `json:"cache_max_ages"`
+	SelftestCanaries          []string         `json:"selftest_canaries"`
+	DebugCapture              bool             `json:"debug_capture"`
+	RequireTenant             bool             `json:"require_tenant"`
+	AdminTokenSet             bool             `json:"admin_token_set"`
+}
+
+func currentConfig() effectiveConfig {
+	return effectiveConfig{
+		ProjectId:                 projectId,
+		ReadOnly:                  readOnly,
+		AutoWarmup:                autoWarmup,
+		DefaultRegion:             defaultRegion,
+		StorageRegion:             string(storageRegion),
+		LogSampleRate:             logSampleRate,
+		ProblemResponses:          problemResponses,
+		PartialSuccessStatus:      partialSuccessStatus,
+		MaxConcurrency:            maxConcurrency,
+		QueueDepth:                queueDepth,
+		QueueMaxWaitSeconds:       int64(queueMaxWait.Seconds()),
+		MaxBodyBytes:              maxBodyBytes,
+		RouteMaxBodyBytes:         routeMaxBodyBytes,
+		BodyReadTimeoutSeconds:    int64(bodyReadTimeout.Seconds()),
+		ReadDeadlineSeconds:       int64(readDeadline.Seconds()),
+		CleanupDeadlineSeconds:    int64(cleanupDeadline.Seconds()),
+		TimestampSkewSeconds:      int64(timestampSkew.Seconds()),
+		FreshnessThresholdSeconds: int64(freshnessThreshold.Seconds()),
+		SyncMaxItems:              maxSyncItems,
+		SyncDedupTTLSeconds:       int64(syncDedupTTL.Seconds()),
+		SyncBlocklistSize:         len(syncBlocklist),
+		ComputeLeaseSeconds:       int64(computeLeaseTTL.Seconds()),
+		ManifestToleranceSeconds:  int64(manifestMatchTolerance.Seconds()),
+		CacheMaxAges:              cacheMaxAges,
+		SelftestCanaries:          canaryNames(),
+		DebugCapture:              debugCapture,
+		RequireTenant:             requireTenant,
+		AdminTokenSet:             adminToken != "",
+	}
+}
+
+type diagnosticsResponse struct {
+	Ready   bool            `json:"ready"`
+	Version rootResponse    `json:"version"`
+	Config  effectiveConfig `json:"config"`
+	Limits  limitsResponse  `json:"limits"`
+	Queue   queueStats      `json:"queue"`
+	Errors  []errorEvent    `json:"errors"`
+}
+
+// handleDiagnostics bundles what the health, identity, admin and error routes report into one document for triage
+func handleDiagnostics(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	if !isAdmin(r) {
+		writeErroneousResponse(w, http.StatusForbidden, "Admin token required")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, diagnosticsResponse{
+		Ready:   isReady(),
+		Version: currentIdentity(),
+		Config:  currentConfig(),
+		Limits:  currentLimits(),
+		Queue:   requestQueue.stats(),
+		Errors:  errorEvents.recent(),
+	})
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/act"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/metric"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+)
+
+// unknownRegionsError lists requested regions that are not in the boot regions
+type unknownRegionsError struct {
+	regions []string
+}
+
+func (e unknownRegionsError) Error() string {
+	return fmt.Sprintf("unknown regions: %s", strings.Join(e.regions, ", "))
+}
+
+// regionScopedRealms narrows every region-realm down to the requested regions
+func regionScopedRealms(sta fn.GatewayState, regionNames []string) (sotah.RegionRealms, error) {
+	regionRealms, err := getAllRegionRealms(sta)
+	if err != nil {
+		return sotah.RegionRealms{}, err
+	}
+
+	out := sotah.RegionRealms{}
+	unknown := []string{}
+	for _, regionName := range regionNames {
+		realms, ok := regionRealms[blizzard.RegionName(regionName)]
+		if !ok {
+			unknown = append(unknown, regionName)
+
+			continue
+		}
+
+		out[blizzard.RegionName(regionName)] = realms
+	}
+	if len(unknown) > 0 {
+		return sotah.RegionRealms{}, unknownRegionsError{regions: unknown}
+	}
+
+	return out, nil
+}
+
+// realmDownloads is the outcome of downloading each realm, where unchanged realms had no new auctions to download
+type realmDownloads struct {
+	tuples    sotah.RegionRealmTimestampTuples
+	unchanged []sotah.RegionRealmTuple
+	failed    []sotah.RegionRealmTuple
+	sizeBytes int
+}
+
+// downloadAuctions calls the act download endpoint as DownloadRegionRealms does, but hands back which realms were
+// unchanged and which failed rather than only logging them
+func downloadAuctions(sta fn.GatewayState, regionRealms sotah.RegionRealms) (realmDownloads, error) {
+	endpoints, err := sta.IO.HellClient.GetActEndpoints()
+	if err != nil {
+		return realmDownloads{}, err
+	}
+
+	logging.WithField("endpoint-url", endpoints.Workload).Info("Producing act client for download-auctions")
+	actClient, err := act.NewClient(endpoints.Workload)
+	if err != nil {
+		return realmDownloads{}, err
+	}
+
+	out := realmDownloads{
+		tuples:    sotah.RegionRealmTimestampTuples{},
+		unchanged: []sotah.RegionRealmTuple{},
+		failed:    []sotah.RegionRealmTuple{},
+	}
+	for outJob := range actClient.DownloadAuctions(regionRealms) {
+		if outJob.Err != nil {
+			logging.WithFields(outJob.ToLogrusFields()).Error("Failed to fetch auctions")
+			out.failed = append(out.failed, outJob.RegionRealmTuple)
+
+			continue
+		}
+
+		switch outJob.Data.Code {
+		case http.StatusCreated:
+			tuple, err := sotah.NewRegionRealmTimestampSizeTuple(string(outJob.Data.Body))
+			if err != nil {
+				logging.WithFields(logrus.Fields{
+					"error":  err.Error(),
+					"region": outJob.RegionName,
+					"realm":  outJob.RealmSlug,
+				}).Error("Failed to decode region-realm-timestamp tuple from act response body")
+				out.failed = append(out.failed, outJob.RegionRealmTuple)
+
+				continue
+			}
+
+			out.tuples = append(out.tuples, tuple.RegionRealmTimestampTuple)
+			out.sizeBytes += tuple.SizeBytes
+		case http.StatusNotModified:
+			out.unchanged = append(out.unchanged, outJob.RegionRealmTuple)
+		default:
+			logging.WithFields(logrus.Fields{
+				"region":      outJob.RegionName,
+				"realm":       outJob.RealmSlug,
+				"status-code": outJob.Data.Code,
+				"data":        fmt.Sprintf("%.50s", string(outJob.Data.Body)),
+			}).Error("Response code for act call was invalid")
+			out.failed = append(out.failed, outJob.RegionRealmTuple)
+		}
+	}
+
+	return out, nil
+}
+
+// publishDownloads reports the download metrics and hands the downloaded tuples on to be received and computed,
+// the same as DownloadRegionRealms and DownloadAllAuctions do once their downloads are done
+func publishDownloads(
+	sta fn.GatewayState,
+	tuples sotah.RegionRealmTimestampTuples,
+	totalRealms int,
+	sizeBytes int,
+	duration time.Duration,
+) error {
+	// reporting metrics
+	if err := sta.IO.BusClient.PublishMetrics(metric.Metrics{
+		"download_all_auctions_duration":   int(duration / time.Second),
+		"download_all_auctions_size_bytes": sizeBytes,
+		"included_realms_downloaded":       len(tuples),
+		"included_realms_total":            totalRealms,
+	}); err != nil {
+		return err
+	}
+
+	// optionally halting on no results
+	if len(tuples) == 0 {
+		logging.Info("No realms were updated")
+
+		return nil
+	}
+
+	if err := sta.PublishDownloadedRegionRealmTuples(tuples); err != nil {
+		return err
+	}
+
+	if err := sta.PublishToCallComputeAllLiveAuctions(tuples); err != nil {
+		return err
+	}
+
+	return sta.PublishToCallComputeAllPricelistHistories(tuples)
+}
+
+// downloadRegionRealms follows the same sequence as downloading all auctions, scoped to the given region-realms
+func downloadRegionRealms(sta fn.GatewayState, regionRealms sotah.RegionRealms) (realmDownloads, error) {
+	startTime := time.Now()
+	downloads, err := downloadAuctions(sta, regionRealms)
+	if err != nil {
+		return realmDownloads{}, err
+	}
+
+	err = publishDownloads(
+		sta,
+		downloads.tuples,
+		regionRealms.TotalRealms(),
+		downloads.sizeBytes,
+		time.Since(startTime),
+	)
+	if err != nil {
+		return realmDownloads{}, err
+	}
+
+	return downloads, nil
+}
+
+// handleRegionScopedDownload downloads the auctions of the requested regions only
+func handleRegionScopedDownload(
+	w http.ResponseWriter,
+	r *http.Request,
+	sta fn.GatewayState,
+	audit *auditEntry,
+	regionNames []string,
+) {
+	regionRealms, err := regionScopedRealms(sta, regionNames)
+	if err != nil {
+		if _, ok := err.(unknownRegionsError); ok {
+			writeErroneousResponse(w, http.StatusUnprocessableEntity, err.Error())
+
+			return
+		}
+
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not gather realms")
+
+		logging.WithField("error", err.Error()).Error("Could not gather realms")
+
+		return
+	}
+
+	audit.Scope = regionNames
+	audit.ScopeSize = regionRealms.TotalRealms()
+
+	if _, err := downloadRegionRealms(sta, regionRealms); err != nil {
+		if writeContextError(w, r, err) {
+			return
+		}
+
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not call download-all-auctions")
+
+		logging.WithField("error", err.Error()).Error("Could not call download-all-auctions")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errorEventMessageLimit bounds how much of an erroneous response body is kept as the event message
+const errorEventMessageLimit = 256
+
+type errorEvent struct {
+	Route     string `json:"route"`
+	RequestId string `json:"request_id"`
+	Status    int    `json:"status"`
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// errorEventBuffer is a fixed-size ring of the most recent error events
+type errorEventBuffer struct {
+	sync.Mutex
+
+	events []errorEvent
+	next   int
+	full   bool
+}
+
+func newErrorEventBuffer(size int) *errorEventBuffer {
+	return &errorEventBuffer{events: make([]errorEvent, size)}
+}
+
+func (buf *errorEventBuffer) add(event errorEvent) {
+	buf.Lock()
+	defer buf.Unlock()
+
+	if len(buf.events) == 0 {
+		return
+	}
+
+	buf.events[buf.next] = event
+	buf.next = (buf.next + 1) % len(buf.events)
+	if buf.next == 0 {
+		buf.full = true
+	}
+}
+
+// recent lists the buffered events from newest to oldest
+func (buf *errorEventBuffer) recent() []errorEvent {
+	buf.Lock()
+	defer buf.Unlock()
+
+	total := buf.next
+	if buf.full {
+		total = len(buf.events)
+	}
+
+	out := []errorEvent{}
+	for i := 1; i <= total; i++ {
+		out = append(out, buf.events[(buf.next-i+len(buf.events))%len(buf.events)])
+	}
+
+	return out
+}
+
+var errorEvents = newErrorEventBuffer(100)
+
+func parseErrorEventsSize() (int, error) {
+	provided := os.Getenv("ERROR_EVENTS_SIZE")
+	if provided == "" {
+		return 100, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 0 {
+		return 0, errors.New("error events size must not be negative")
+	}
+
+	return parsed, nil
+}
+
+// recordErrorEvent keeps server-side failures, as client errors are not the gateway's to investigate
+func recordErrorEvent(r *http.Request, routePath string, recorder *statusRecorder) {
+	if recorder.status < http.StatusInternalServerError {
+		return
+	}
+
+	errorEvents.add(errorEvent{
+		Route:     routePath,
+		RequestId: requestId(r),
+		Status:    recorder.status,
+		Message:   string(recorder.errorBody),
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// adminToken is the token required by admin routes, which are refused entirely when it is unset
+var adminToken string
+
+func isAdmin(r *http.Request) bool {
+	if adminToken == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(adminToken)) == 1
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/hell"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah/gameversions"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+)
+
+// freshnessThreshold is how long a realm may go without a successful download or compute before it is flagged
+var freshnessThreshold = 2 * time.Hour
+
+func parseFreshnessThreshold() (time.Duration, error) {
+	provided := os.Getenv("FRESHNESS_THRESHOLD_SECONDS")
+	if provided == "" {
+		return 2 * time.Hour, nil
+	}
+
+	seconds, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if seconds <= 0 {
+		return 0, errors.New("freshness threshold must be positive")
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+type realmFreshness struct {
+	Region                     string `json:"region"`
+	Realm                      string `json:"realm"`
+	Downloaded                 int    `json:"downloaded"`
+	LiveAuctionsReceived       int    `json:"live_auctions_received"`
+	PricelistHistoriesReceived int    `json:"pricelist_histories_received"`
+	Stale                      bool   `json:"stale"`
+}
+
+type freshnessResponse struct {
+	ThresholdSeconds int              `json:"threshold_seconds"`
+	TotalStale       int              `json:"total_stale"`
+	Realms           []realmFreshness `json:"realms"`
+}
+
+// getFreshness reports the last successful download and compute timestamps that hell holds for each realm
+func getFreshness(sta fn.GatewayState) (freshnessResponse, error) {
+	regionRealms, err := getAllRegionRealms(sta)
+	if err != nil {
+		return freshnessResponse{}, err
+	}
+
+	hellRegionRealms, err := sta.IO.HellClient.GetRegionRealms(regionRealms.ToRegionRealmSlugs(), gameversions.Retail)
+	if err != nil {
+		return freshnessResponse{}, err
+	}
+
+	staleBefore := int(time.Now().Add(-freshnessThreshold).Unix())
+	out := freshnessResponse{
+		ThresholdSeconds: int(freshnessThreshold / time.Second),
+		Realms:           []realmFreshness{},
+	}
+	for regionName, hellRealms := range hellRegionRealms {
+		for realmSlug, hellRealm := range hellRealms {
+			result := realmFreshness{
+				Region:                     string(regionName),
+				Realm:                      string(realmSlug),
+				Downloaded:                 hellRealm.Downloaded,
+				LiveAuctionsReceived:       hellRealm.LiveAuctionsReceived,
+				PricelistHistoriesReceived: hellRealm.PricelistHistoriesReceived,
+				Stale:                      hellRealm.Downloaded < staleBefore || hellRealm.LiveAuctionsReceived < staleBefore,
+			}
+			if result.Stale {
+				out.TotalStale++
+			}
+
+			out.Realms = append(out.Realms, result)
+		}
+	}
+
+	return out, nil
+}
+
+// filterUnchangedTuples splits off tuples whose target timestamp has already been computed, per hell
+func filterUnchangedTuples(
+	sta fn.GatewayState,
+	tuples sotah.RegionRealmTimestampTuples,
+	computed func(hellRealm hell.Realm) int,
+) (sotah.RegionRealmTimestampTuples, sotah.RegionRealmTimestampTuples, error) {
+	hellRegionRealms, err := sta.IO.HellClient.GetRegionRealms(tuples.ToRegionRealmSlugs(), gameversions.Retail)
+	if err != nil {
+		return sotah.RegionRealmTimestampTuples{}, sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	changed := sotah.RegionRealmTimestampTuples{}
+	unchanged := sotah.RegionRealmTimestampTuples{}
+	for _, tuple := range tuples {
+		hellRealm := hellRegionRealms[blizzard.RegionName(tuple.RegionName)][blizzard.RealmSlug(tuple.RealmSlug)]
+		if tuple.TargetTimestamp <= computed(hellRealm) {
+			unchanged = append(unchanged, tuple)
+
+			continue
+		}
+
+		changed = append(changed, tuple)
+	}
+
+	return changed, unchanged, nil
+}
+
+// resolveLatestTuples fills in tuples that omit their timestamp with the realm's latest downloaded timestamp,
+// handing back separately any tuples whose realm has never been downloaded
+func resolveLatestTuples(
+	sta fn.GatewayState,
+	tuples sotah.RegionRealmTimestampTuples,
+) (sotah.RegionRealmTimestampTuples, sotah.RegionRealmTimestampTuples, error) {
+	hellRegionRealms, err := sta.IO.HellClient.GetRegionRealms(tuples.ToRegionRealmSlugs(), gameversions.Retail)
+	if err != nil {
+		return sotah.RegionRealmTimestampTuples{}, sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	resolved := sotah.RegionRealmTimestampTuples{}
+	unresolved := sotah.RegionRealmTimestampTuples{}
+	for _, tuple := range tuples {
+		if tuple.TargetTimestamp == 0 {
+			hellRealm := hellRegionRealms[blizzard.RegionName(tuple.RegionName)][blizzard.RealmSlug(tuple.RealmSlug)]
+			if hellRealm.Downloaded == 0 {
+				unresolved = append(unresolved, tuple)
+
+				continue
+			}
+
+			tuple.TargetTimestamp = hellRealm.Downloaded
+		}
+
+		resolved = append(resolved, tuple)
+	}
+
+	return resolved, unresolved, nil
+}
@@ -6,4 +6,6 @@ require (
 	cloud.google.com/go v0.36.0
 	github.com/sirupsen/logrus v1.4.2
 	github.com/sotah-inc/steamwheedle-cartel v0.0.0-20190920173040-d318ef67ed41
+	google.golang.org/api v0.1.0
+	google.golang.org/grpc v1.17.0
 )
@@ -0,0 +1,497 @@
+package app
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+)
+
+func handleDownloadAllAuctions(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	// optionally sharding the download by region
+	if regionNames := r.URL.Query()["region"]; len(regionNames) > 0 {
+		handleRegionScopedDownload(w, r, sta, audit, regionNames)
+
+		return
+	}
+
+	// optionally resuming from the checkpoint of the current download cycle
+	if downloadCycle > 0 {
+		handleCheckpointedDownload(w, r, sta, audit)
+
+		return
+	}
+
+	if err := sta.DownloadAllAuctions(); err != nil {
+		if writeContextError(w, r, err) {
+			return
+		}
+
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not call download-all-auctions")
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not call download-all-auctions")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func handleCleanupAllManifests(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	regionRealms, err := getAllRegionRealms(sta)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not gather realms")
+
+		logging.WithField("error", err.Error()).Error("Could not gather realms")
+
+		return
+	}
+
+	counts, err := cleanupManifests(sta, regionRealms)
+	if err != nil {
+		if writeContextError(w, r, err) {
+			return
+		}
+
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not call cleanup-all-manifests")
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not call Could not call cleanup-all-manifests")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, counts)
+}
+
+func handleCleanupAllAuctions(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	regionRealms, err := getAllRegionRealms(sta)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not gather realms")
+
+		logging.WithField("error", err.Error()).Error("Could not gather realms")
+
+		return
+	}
+
+	counts, err := cleanupAuctions(sta, regionRealms)
+	if err != nil {
+		if writeContextError(w, r, err) {
+			return
+		}
+
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not call cleanup-all-auctions")
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not call Could not call cleanup-all-auctions")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, counts)
+}
+
+func handleSyncAllItems(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	// streaming item-ids line by line when sent as ndjson
+	if isNDJSON(r) {
+		ids, err := decodeNDJSONItemIds(r.Body)
+		if err != nil {
+			switch {
+			case err == errTooManyItemIds:
+				writeErroneousResponse(w, http.StatusRequestEntityTooLarge, err.Error())
+			case !writeBodyReadError(w, err):
+				writeErroneousResponse(w, http.StatusBadRequest, "Could not decode item-ids from request body")
+			}
+
+			logging.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Could not decode item-ids from request body")
+
+			return
+		}
+
+		syncItemIds(w, r, sta, audit, ids)
+
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		if !writeBodyReadError(w, err) {
+			writeErroneousResponse(w, http.StatusInternalServerError, "Could not read request body")
+		}
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not read request body")
+
+		return
+	}
+
+	ids, err := blizzard.NewItemIds(string(body))
+	if err != nil {
+		writeErroneousResponse(
+			w,
+			http.StatusBadRequest,
+			"Could not decode item-ids from request body",
+		)
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not decode item-ids from request body")
+
+		return
+	}
+	if maxSyncItems > 0 && len(ids) > maxSyncItems {
+		writeErroneousResponse(w, http.StatusRequestEntityTooLarge, errTooManyItemIds.Error())
+
+		return
+	}
+
+	syncItemIds(w, r, sta, audit, ids)
+}
+
+func syncItemIds(
+	w http.ResponseWriter,
+	r *http.Request,
+	sta fn.GatewayState,
+	audit *auditEntry,
+	ids blizzard.ItemIds,
+) {
+	audit.ScopeSize = len(ids)
+
+	// answering repeats of a recently synced item-id set with the prior result
+	dedupKey := ""
+	if syncDedupTTL > 0 {
+		dedupKey = syncDedupKey(ids)
+		prior, ok, err := getRecentSync(sta, dedupKey)
+		if err != nil {
+			logging.WithField("error", err.Error()).Warn("Could not check for a recent sync, syncing regardless")
+		} else if ok {
+			logging.WithField("dedup-key", dedupKey).Info("Skipping repeat of a recent sync")
+
+			writeJSONResponse(w, http.StatusAccepted, prior)
+
+			return
+		}
+	}
+
+	// dropping item-ids that are known to never sync
+	ids, blocked := filterBlockedItemIds(ids)
+	if len(blocked) > 0 {
+		logging.WithField("blocked", blocked).Info("Skipping blocked item-ids")
+	}
+
+	if err := sta.SyncAllItems(ids); err != nil {
+		if writeContextError(w, r, err) {
+			return
+		}
+
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not call sync-all-items")
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not call sync-all-items")
+
+		return
+	}
+
+	resp := syncItemsResponse{Blocked: blocked}
+	if dedupKey != "" {
+		if err := recordSync(sta, dedupKey, resp); err != nil {
+			logging.WithField("error", err.Error()).Error("Failed to record sync for dedup")
+		}
+	}
+
+	writeJSONResponse(w, http.StatusCreated, resp)
+}
+
+func handleCleanupAllPricelistHistories(
+	w http.ResponseWriter,
+	r *http.Request,
+	sta fn.GatewayState,
+	audit *auditEntry,
+) {
+	resp, err := cleanupPricelistHistoriesFrom(sta, r.URL.Query().Get("cursor"))
+	if err != nil {
+		if writeContextError(w, r, err) {
+			return
+		}
+
+		writeErroneousResponse(
+			w,
+			http.StatusInternalServerError,
+			"Could not call cleanup-all-pricelist-histories",
+		)
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not call Could not call cleanup-all-pricelist-histories")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, resp)
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	// probes do not require gateway state, though they do wait on auto-warmup
+	if !isReady() {
+		writeErroneousResponse(w, http.StatusServiceUnavailable, "Warmup has not completed")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleItem(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeErroneousResponse(w, http.StatusBadRequest, "Could not parse item-id from query")
+
+		return
+	}
+
+	// passing gzip-stored items through untouched when the client accepts gzip
+	if acceptsGzip(r) {
+		served, err := serveCompressedItem(w, sta, blizzard.ItemID(id))
+		if err != nil {
+			logging.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"item":  id,
+			}).Error("Could not serve compressed item")
+
+			if !served {
+				writeErroneousResponse(w, http.StatusInternalServerError, "Could not fetch item")
+			}
+
+			return
+		}
+		if served {
+			return
+		}
+	}
+
+	item, exists, err := getItem(sta, blizzard.ItemID(id))
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not fetch item")
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"item":  id,
+		}).Error("Could not fetch item")
+
+		return
+	}
+	if !exists {
+		writeErroneousResponse(w, http.StatusNotFound, "Item not found")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, item)
+}
+
+func handlePing(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	// narrowing down to the dependencies of a single route when provided
+	names := allDependencies()
+	if routePath := r.URL.Query().Get("route"); routePath != "" {
+		rt, ok := findRoute(routePath)
+		if !ok {
+			writeErroneousResponse(w, http.StatusBadRequest, "Route not found")
+
+			return
+		}
+
+		names = rt.Dependencies
+	}
+
+	resp := pingDependencies(sta, names)
+	if !resp.Reachable {
+		logging.WithField("dependencies", resp.Dependencies).Error("Dependencies were unreachable")
+
+		writeJSONResponse(w, http.StatusServiceUnavailable, resp)
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, resp)
+}
+
+func handleAudit(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	query, err := newAuditQuery(r)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	entries, err := newAuditStore(sta).Query(query)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not query audit entries")
+
+		logging.WithField("error", err.Error()).Error("Could not query audit entries")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, entries)
+}
+
+func handleFreshness(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	resp, err := getFreshness(sta)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not gather realm freshness")
+
+		logging.WithField("error", err.Error()).Error("Could not gather realm freshness")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, resp)
+}
+
+func handleItemsExport(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	started, err := exportItems(w, sta, acceptsGzip(r), r.URL.Query().Get("cursor"))
+	if err != nil {
+		logging.WithField("error", err.Error()).Error("Could not export items")
+
+		if !started {
+			writeErroneousResponse(w, http.StatusInternalServerError, "Could not export items")
+		}
+	}
+}
+
+func handleItemsPurge(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		if !writeBodyReadError(w, err) {
+			writeErroneousResponse(w, http.StatusInternalServerError, "Could not read request body")
+		}
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not read request body")
+
+		return
+	}
+
+	ids, err := blizzard.NewItemIds(string(body))
+	if err != nil {
+		writeErroneousResponse(
+			w,
+			http.StatusBadRequest,
+			"Could not decode item-ids from request body",
+		)
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not decode item-ids from request body")
+
+		return
+	}
+
+	audit.ScopeSize = len(ids)
+
+	outcomes, err := purgeItems(sta, ids)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not purge items")
+
+		logging.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not purge items")
+
+		return
+	}
+
+	logging.WithField("items", outcomes).Info("Purged items")
+
+	writeJSONResponse(w, http.StatusOK, itemPurgeResponse{Items: outcomes, IndexRetained: true})
+}
+
+func handleErrors(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	if !isAdmin(r) {
+		writeErroneousResponse(w, http.StatusForbidden, "Admin token required")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, errorEvents.recent())
+}
+
+func handleItemsCount(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	filter, err := newItemCountFilter(r.URL.Query())
+	if err != nil {
+		writeErroneousResponse(w, http.StatusBadRequest, "Could not parse item filters from query")
+
+		return
+	}
+
+	resp, err := countItems(sta, filter, r.URL.Query().Get("cursor"))
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not count items")
+
+		logging.WithField("error", err.Error()).Error("Could not count items")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, resp)
+}
+
+func handleAdminLimits(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	if !isAdmin(r) {
+		writeErroneousResponse(w, http.StatusForbidden, "Admin token required")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, currentLimits())
+}
+
+// handleDecodeTuples converts a legacy bare array of tuples into the canonical envelope, with no side effects
+func handleDecodeTuples(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		if !writeBodyReadError(w, err) {
+			writeErroneousResponse(w, http.StatusInternalServerError, "Could not read request body")
+		}
+
+		return
+	}
+
+	tuples, err := sotah.NewRegionRealmTimestampTuples(string(body))
+	if err != nil {
+		writeErroneousResponse(w, http.StatusBadRequest, "Could not decode region-realm-timestamp tuples from request body")
+
+		return
+	}
+	for i, tuple := range tuples {
+		tuples[i] = normalizeTuple(tuple)
+	}
+
+	writeJSONResponse(w, http.StatusOK, canonicalComputeRequest{
+		Tuples:  tuples,
+		Options: newComputeOptions(r.URL.Query()),
+	})
+}
+
+func handleAdminQueue(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	if !isAdmin(r) {
+		writeErroneousResponse(w, http.StatusForbidden, "Admin token required")
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, requestQueue.stats())
+}
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah/gameversions"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/store"
+	"google.golang.org/api/iterator"
+)
+
+// integritySampleSize is the default number of stored objects sampled per integrity check
+var integritySampleSize = 20
+
+func parseIntegritySampleSize() (int, error) {
+	provided := os.Getenv("INTEGRITY_SAMPLE_SIZE")
+	if provided == "" {
+		return 20, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed <= 0 {
+		return 0, errors.New("integrity sample size must be positive")
+	}
+
+	return parsed, nil
+}
+
+// integrityCategory is a kind of stored object that may be sampled, along with how to verify one decodes
+// prefixes partitions the bucket, so that a sample lists a few random partitions rather than the whole bucket
+type integrityCategory struct {
+	bucket   func(sta fn.GatewayState) (*storage.BucketHandle, error)
+	prefixes func(sta fn.GatewayState) ([]string, error)
+	verify   func(sta fn.GatewayState, obj *storage.ObjectHandle) error
+}
+
+func newAuctionsBase(sta fn.GatewayState) store.AuctionsBaseV2 {
+	return store.NewAuctionsBaseV2(sta.IO.StoreClient, storageRegion, gameversions.Retail)
+}
+
+var integrityCategories = map[string]integrityCategory{
+	"items": {
+		bucket: func(sta fn.GatewayState) (*storage.BucketHandle, error) {
+			return newItemsBase(sta).GetFirmBucket()
+		},
+		prefixes: func(sta fn.GatewayState) ([]string, error) {
+			// partitioning item objects by the leading digit of their item-id
+			out := []string{}
+			for digit := 1; digit <= 9; digit++ {
+				out = append(out, fmt.Sprintf("%s/%d", gameversions.Retail, digit))
+			}
+
+			return out, nil
+		},
+		verify: func(sta fn.GatewayState, obj *storage.ObjectHandle) error {
+			_, err := newItemsBase(sta).NewItem(obj)
+
+			return err
+		},
+	},
+	"auctions": {
+		bucket: func(sta fn.GatewayState) (*storage.BucketHandle, error) {
+			return newAuctionsBase(sta).GetFirmBucket()
+		},
+		prefixes: func(sta fn.GatewayState) ([]string, error) {
+			regionRealms, err := getAllRegionRealms(sta)
+			if err != nil {
+				return []string{}, err
+			}
+
+			// partitioning auction objects by realm
+			out := []string{}
+			for _, realms := range regionRealms {
+				for _, realm := range realms {
+					out = append(out, newAuctionsBase(sta).GetObjectPrefix(realm)+"/")
+				}
+			}
+
+			return out, nil
+		},
+		verify: func(sta fn.GatewayState, obj *storage.ObjectHandle) error {
+			reader, err := obj.NewReader(sta.IO.StoreClient.Context)
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			body, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+
+			_, err = blizzard.NewAuctions(body)
+
+			return err
+		},
+	},
+}
+
+type integrityFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+type integritySampleResponse struct {
+	Category string             `json:"category"`
+	Sampled  int                `json:"sampled"`
+	Passed   bool               `json:"passed"`
+	Failures []integrityFailure `json:"failures"`
+}
+
+// sampleObjectNames picks up to size object names at random from the bucket, listing random prefixes one at a
+// time until the sample is full, and listing names only so that object contents are never read beyond the sample
+func sampleObjectNames(sta fn.GatewayState, bkt *storage.BucketHandle, prefixes []string, size int) ([]string, error) {
+	out := []string{}
+	for _, i := range rand.Perm(len(prefixes)) {
+		if len(out) >= size {
+			break
+		}
+
+		names, err := samplePrefixNames(sta, bkt, prefixes[i], size-len(out))
+		if err != nil {
+			return []string{}, err
+		}
+
+		out = append(out, names...)
+	}
+
+	return out, nil
+}
+
+// samplePrefixNames picks up to size object names uniformly at random from the objects under the prefix
+func samplePrefixNames(sta fn.GatewayState, bkt *storage.BucketHandle, prefix string, size int) ([]string, error) {
+	it := bkt.Objects(sta.IO.StoreClient.Context, &storage.Query{Prefix: prefix})
+	out := []string{}
+	seen := 0
+	for {
+		objAttrs, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+
+			return []string{}, err
+		}
+
+		// reservoir sampling, as the number of objects is not known ahead of listing them
+		seen++
+		if len(out) < size {
+			out = append(out, objAttrs.Name)
+
+			continue
+		}
+		if i := rand.Intn(seen); i < size {
+			out[i] = objAttrs.Name
+		}
+	}
+
+	return out, nil
+}
+
+func sampleIntegrity(
+	sta fn.GatewayState,
+	categoryName string,
+	category integrityCategory,
+	size int,
+) (integritySampleResponse, error) {
+	bkt, err := category.bucket(sta)
+	if err != nil {
+		return integritySampleResponse{}, err
+	}
+
+	prefixes, err := category.prefixes(sta)
+	if err != nil {
+		return integritySampleResponse{}, err
+	}
+
+	names, err := sampleObjectNames(sta, bkt, prefixes, size)
+	if err != nil {
+		return integritySampleResponse{}, err
+	}
+
+	out := integritySampleResponse{Category: categoryName, Sampled: len(names), Failures: []integrityFailure{}}
+	for _, name := range names {
+		if err := category.verify(sta, bkt.Object(name)); err != nil {
+			out.Failures = append(out.Failures, integrityFailure{Path: name, Error: err.Error()})
+		}
+	}
+	out.Passed = len(out.Failures) == 0
+
+	return out, nil
+}
+
+func handleIntegritySample(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	categoryName := r.URL.Query().Get("category")
+	category, ok := integrityCategories[categoryName]
+	if !ok {
+		writeErroneousResponse(w, http.StatusBadRequest, "Category must be one of items or auctions")
+
+		return
+	}
+
+	size := integritySampleSize
+	if provided := r.URL.Query().Get("size"); provided != "" {
+		parsed, err := strconv.Atoi(provided)
+		if err != nil || parsed <= 0 {
+			writeErroneousResponse(w, http.StatusBadRequest, "Could not parse sample size from query")
+
+			return
+		}
+
+		size = parsed
+	}
+
+	resp, err := sampleIntegrity(sta, categoryName, category, size)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not sample stored objects")
+
+		logging.WithFields(logrus.Fields{
+			"error":    err.Error(),
+			"category": categoryName,
+		}).Error("Could not sample stored objects")
+
+		return
+	}
+	if !resp.Passed {
+		logging.WithFields(logrus.Fields{
+			"category": categoryName,
+			"failures": resp.Failures,
+		}).Warn("Sampled stored objects failed to decode")
+	}
+
+	writeJSONResponse(w, http.StatusOK, resp)
+}
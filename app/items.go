@@ -0,0 +1,366 @@
+package app
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah/gameversions"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/store"
+	"google.golang.org/api/iterator"
+)
+
+func newItemsBase(sta fn.GatewayState) store.ItemsBase {
+	return store.NewItemsBase(sta.IO.StoreClient, storageRegion, gameversions.Retail)
+}
+
+// getItem reads the stored item data for an item-id, flagging whether it was found
+func getItem(sta fn.GatewayState, id blizzard.ItemID) (sotah.Item, bool, error) {
+	itemsBase := newItemsBase(sta)
+	obj := itemsBase.GetObject(id, itemsBase.GetBucket())
+
+	// checking that the item has been synced
+	exists, err := itemsBase.ObjectExists(obj)
+	if err != nil {
+		return sotah.Item{}, false, err
+	}
+	if !exists {
+		return sotah.Item{}, false, nil
+	}
+
+	item, err := itemsBase.NewItem(obj)
+	if err != nil {
+		return sotah.Item{}, false, err
+	}
+
+	return item, true, nil
+}
+
+// serveCompressedItem streams a gzip-stored item to the client without decompressing it, flagging whether it did so
+// items that are missing or not stored gzip-encoded are left to the decoding path
+func serveCompressedItem(w http.ResponseWriter, sta fn.GatewayState, id blizzard.ItemID) (bool, error) {
+	itemsBase := newItemsBase(sta)
+	obj := itemsBase.GetObject(id, itemsBase.GetBucket())
+
+	attrs, err := obj.Attrs(sta.IO.StoreClient.Context)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return false, nil
+		}
+
+		return false, err
+	}
+	if attrs.ContentEncoding != "gzip" {
+		return false, nil
+	}
+
+	reader, err := obj.ReadCompressed(true).NewReader(sta.IO.StoreClient.Context)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, reader); err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// exportFlushInterval is the number of items written between flushes of an export
+const exportFlushInterval = 100
+
+// readDeadline is the soft deadline past which streaming reads are truncated, where zero disables it
+var readDeadline = 50 * time.Second
+
+func parseReadDeadline() (time.Duration, error) {
+	provided := os.Getenv("READ_DEADLINE_SECONDS")
+	if provided == "" {
+		return 50 * time.Second, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 0 {
+		return 0, errors.New("read deadline seconds must not be negative")
+	}
+
+	return time.Duration(parsed) * time.Second, nil
+}
+
+// exportTruncation is the final line of an export that hit the read deadline
+// passing its cursor back resumes the export after the last item written
+type exportTruncation struct {
+	Truncated bool   `json:"truncated"`
+	Cursor    string `json:"cursor"`
+}
+
+// exportFailure is the final line of an export that failed after it started
+// passing its cursor back resumes the export after the last item written
+type exportFailure struct {
+	Error  string `json:"error"`
+	Cursor string `json:"cursor"`
+}
+
+// exportItems streams every synced item after the cursor as newline-delimited json, flushing periodically
+// the response is gzip-encoded when the client accepts it, and errors past the first write are flagged as started
+// and end the export with an exportFailure line, so that a failed export is not mistaken for a complete one
+func exportItems(w http.ResponseWriter, sta fn.GatewayState, gzipped bool, cursor string) (bool, error) {
+	startTime := time.Now()
+
+	itemsBase := newItemsBase(sta)
+	bkt, err := itemsBase.GetFirmBucket()
+	if err != nil {
+		return false, err
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	var out io.Writer = w
+	var gzipWriter *gzip.Writer
+	if gzipped {
+		w.Header().Set("Content-Encoding", "gzip")
+		gzipWriter, err = gzip.NewWriterLevel(w, gzipLevel)
+		if err != nil {
+			return false, err
+		}
+		defer gzipWriter.Close()
+		out = gzipWriter
+	}
+	w.WriteHeader(http.StatusOK)
+
+	flush := func() error {
+		if gzipWriter != nil {
+			if err := gzipWriter.Flush(); err != nil {
+				return err
+			}
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		return nil
+	}
+
+	encoder := json.NewEncoder(out)
+	fail := func(err error) (bool, error) {
+		// writing the failure line on a best-effort basis, as the stream itself may be what failed
+		if encodeErr := encoder.Encode(exportFailure{Error: "Could not export items", Cursor: cursor}); encodeErr == nil {
+			flush()
+		}
+
+		return true, err
+	}
+
+	it := bkt.Objects(sta.IO.StoreClient.Context, &storage.Query{Prefix: fmt.Sprintf("%s/", itemsBase.GameVersion)})
+	written := 0
+	for {
+		objAttrs, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+
+			return fail(err)
+		}
+
+		// skipping items written ahead of the cursor, as objects are listed in lexicographic order
+		if objAttrs.Name <= cursor {
+			continue
+		}
+
+		// truncating once the read deadline has passed
+		if readDeadline > 0 && time.Since(startTime) > readDeadline {
+			if err := encoder.Encode(exportTruncation{Truncated: true, Cursor: cursor}); err != nil {
+				return true, err
+			}
+
+			break
+		}
+
+		item, err := itemsBase.NewItem(bkt.Object(objAttrs.Name))
+		if err != nil {
+			return fail(err)
+		}
+
+		if err := encoder.Encode(item); err != nil {
+			return true, err
+		}
+		cursor = objAttrs.Name
+
+		written++
+		if written%exportFlushInterval == 0 {
+			if err := flush(); err != nil {
+				return true, err
+			}
+		}
+	}
+
+	return true, flush()
+}
+
+type itemPurgeOutcome string
+
+const (
+	itemPurged   itemPurgeOutcome = "purged"
+	itemNotFound itemPurgeOutcome = "not-found"
+)
+
+type itemPurgeResponse struct {
+	Items map[blizzard.ItemID]itemPurgeOutcome `json:"items"`
+
+	// IndexRetained flags that the items database entries the sync filter reads were left in place, as that
+	// database belongs to the bus listener serving filter-in-items-to-sync and the gateway cannot reach it
+	IndexRetained bool `json:"index_retained"`
+}
+
+// purgeItems deletes the stored item object of each item
+// the sync filter still finds purged items in the items database, so they are only re-synced once removed there too
+func purgeItems(sta fn.GatewayState, ids blizzard.ItemIds) (map[blizzard.ItemID]itemPurgeOutcome, error) {
+	itemsBase := newItemsBase(sta)
+	bkt, err := itemsBase.GetFirmBucket()
+	if err != nil {
+		return map[blizzard.ItemID]itemPurgeOutcome{}, err
+	}
+
+	out := map[blizzard.ItemID]itemPurgeOutcome{}
+	for _, id := range ids {
+		if err := itemsBase.GetObject(id, bkt).Delete(sta.IO.StoreClient.Context); err != nil {
+			if err == storage.ErrObjectNotExist {
+				out[id] = itemNotFound
+
+				continue
+			}
+
+			return map[blizzard.ItemID]itemPurgeOutcome{}, err
+		}
+
+		out[id] = itemPurged
+	}
+
+	return out, nil
+}
+
+// itemCountFilter narrows an item count down to items matching each provided field
+type itemCountFilter struct {
+	quality   *int
+	itemClass *blizzard.ItemClassClass
+}
+
+func newItemCountFilter(query url.Values) (itemCountFilter, error) {
+	out := itemCountFilter{}
+	if provided := query.Get("quality"); provided != "" {
+		quality, err := strconv.Atoi(provided)
+		if err != nil {
+			return itemCountFilter{}, err
+		}
+
+		out.quality = &quality
+	}
+	if provided := query.Get("item_class"); provided != "" {
+		parsed, err := strconv.Atoi(provided)
+		if err != nil {
+			return itemCountFilter{}, err
+		}
+
+		itemClass := blizzard.ItemClassClass(parsed)
+		out.itemClass = &itemClass
+	}
+
+	return out, nil
+}
+
+func (filter itemCountFilter) isEmpty() bool {
+	return filter.quality == nil && filter.itemClass == nil
+}
+
+func (filter itemCountFilter) matches(item sotah.Item) bool {
+	if filter.quality != nil && item.Quality != *filter.quality {
+		return false
+	}
+	if filter.itemClass != nil && item.ItemClass != *filter.itemClass {
+		return false
+	}
+
+	return true
+}
+
+type itemCountResponse struct {
+	Count int `json:"count"`
+
+	// Truncated flags a count that hit the read deadline, where passing its cursor back resumes the count after the
+	// last item counted, and the counts of each invocation are summed by the caller
+	Truncated bool   `json:"truncated"`
+	Cursor    string `json:"cursor,omitempty"`
+}
+
+// countItems counts synced items after the cursor from the bucket listing, which takes one read per listed page
+// filtering on item fields takes an O(items) read of every item, so counts are truncated at the read deadline
+func countItems(sta fn.GatewayState, filter itemCountFilter, cursor string) (itemCountResponse, error) {
+	startTime := time.Now()
+
+	itemsBase := newItemsBase(sta)
+	bkt, err := itemsBase.GetFirmBucket()
+	if err != nil {
+		return itemCountResponse{}, err
+	}
+
+	it := bkt.Objects(sta.IO.StoreClient.Context, &storage.Query{Prefix: fmt.Sprintf("%s/", itemsBase.GameVersion)})
+	out := itemCountResponse{}
+	for {
+		objAttrs, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+
+			return itemCountResponse{}, err
+		}
+
+		// skipping items counted ahead of the cursor, as objects are listed in lexicographic order
+		if objAttrs.Name <= cursor {
+			continue
+		}
+
+		// truncating once the read deadline has passed
+		if readDeadline > 0 && time.Since(startTime) > readDeadline {
+			out.Truncated = true
+			out.Cursor = cursor
+
+			break
+		}
+
+		matched := true
+		if !filter.isEmpty() {
+			item, err := itemsBase.NewItem(bkt.Object(objAttrs.Name))
+			if err != nil {
+				return itemCountResponse{}, err
+			}
+
+			matched = filter.matches(item)
+		}
+		cursor = objAttrs.Name
+
+		if matched {
+			out.Count++
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,20 @@
+package app
+
+import (
+	"errors"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// parseLogFormatter resolves LOG_FORMAT to a logrus formatter, defaulting to json for stackdriver
+func parseLogFormatter() (logrus.Formatter, error) {
+	switch os.Getenv("LOG_FORMAT") {
+	case "", "json":
+		return &logrus.JSONFormatter{}, nil
+	case "text":
+		return &logrus.TextFormatter{}, nil
+	default:
+		return nil, errors.New("log format must be one of json or text")
+	}
+}
@@ -1,39 +1,44 @@
 package app
 
 import (
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/sirupsen/logrus"
-	"github.com/sotah-inc/steamwheedle-cartel/pkg/act"
-	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
 	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
 	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging/stackdriver"
-	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
 	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
 )
 
 var serviceName string
 var projectId string
-var state fn.GatewayState
+var serviceVersion string
+
+// readOnly disables every mutating route
+var readOnly bool
 
 func init() {
 	var err error
 
-	// resolving project-id
+	// resolving project-id, leaving it blank when the metadata server cannot be reached, as preflightState then
+	// answers with a 503 until resolveState looks it up again
 	projectId, err = metadata.Get("project/project-id")
 	if err != nil {
-		log.Fatalf("Failed to get project-id: %s", err.Error())
-
-		return
+		log.Printf("Failed to get project-id: %s", err.Error())
 	}
 
 	// resolving service name
 	serviceName = os.Getenv("FUNCTION_NAME")
 
+	// resolving service version, falling back to the deployed function version
+	serviceVersion = os.Getenv("SERVICE_VERSION")
+	if serviceVersion == "" {
+		serviceVersion = os.Getenv("X_GOOGLE_FUNCTION_VERSION")
+	}
+
 	// establishing log verbosity
 	logVerbosity, err := logrus.ParseLevel("info")
 	if err != nil {
@@ -43,202 +48,374 @@ func init() {
 	}
 	logging.SetLevel(logVerbosity)
 
-	// adding stackdriver hook
-	logging.WithField("project-id", projectId).Info("Creating stackdriver hook")
-	stackdriverHook, err := stackdriver.NewHook(projectId, serviceName)
+	// establishing log format
+	logFormatter, err := parseLogFormatter()
 	if err != nil {
-		logging.WithFields(logrus.Fields{
-			"error":     err.Error(),
-			"projectID": projectId,
-		}).Fatal("Could not create new stackdriver logrus hook")
+		logging.WithField("error", err.Error()).Fatal("Could not parse log format")
 
 		return
 	}
-	logging.AddHook(stackdriverHook)
+	// the logging package exposes its logger only through entries
+	logging.WithFields(logrus.Fields{}).Logger.SetFormatter(logFormatter)
 
-	// done preliminary setup
-	logging.WithField("service", serviceName).Info("Initializing service")
+	// establishing log sample rate
+	logSampleRate, err = parseLogSampleRate()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse log sample rate")
 
-	// producing gateway state
-	logging.WithFields(logrus.Fields{
-		"project":      projectId,
-		"service-name": serviceName,
-	}).Info("Producing fn-gateway state")
+		return
+	}
 
-	state, err = fn.NewGatewayState(
-		fn.GatewayStateConfig{ProjectId: projectId},
-	)
+	// establishing body read warn thresholds
+	bodyWarnBytes, bodyWarnDuration, err = parseBodyWarnThresholds()
 	if err != nil {
-		log.Fatalf("Failed to generate compute-live-auctions state: %s", err.Error())
+		logging.WithField("error", err.Error()).Fatal("Could not parse body warn thresholds")
 
 		return
 	}
 
-	// fin
-	logging.Info("Finished init")
-}
+	// establishing headers to redact from logs
+	redactedHeaders = parseRedactedHeaders()
 
-func FnGateway(w http.ResponseWriter, r *http.Request) {
-	logging.Info("Received request")
+	// establishing sync blocklist
+	syncBlocklist, err = parseSyncBlocklist()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse sync blocklist")
 
-	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// establishing sync item cap
+	maxSyncItems, err = parseMaxSyncItems()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse sync max items")
 
 		return
 	}
 
-	switch r.URL.Path {
-	case "/download-all-auctions":
-		if err := state.DownloadAllAuctions(); err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not call download-all-auctions", err)
+	// establishing sync dedup window
+	syncDedupTTL, err = parseSyncDedupTTL()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse sync dedup ttl")
 
-			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not call download-all-auctions")
+		return
+	}
 
-			return
-		}
+	// establishing manifest match tolerance
+	manifestMatchTolerance, err = parseManifestMatchTolerance()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse manifest match tolerance")
 
-		w.WriteHeader(http.StatusCreated)
-	case "/cleanup-all-manifests":
-		if err := state.CleanupAllManifests(); err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not call cleanup-all-manifests", err)
+		return
+	}
 
-			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not call Could not call cleanup-all-manifests")
+	// establishing compute coalescing lease
+	computeLeaseTTL, err = parseComputeLeaseTTL()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse compute lease seconds")
 
-			return
-		}
+		return
+	}
 
-		w.WriteHeader(http.StatusOK)
-	case "/cleanup-all-auctions":
-		if err := state.CleanupAllAuctions(); err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not call cleanup-all-auctions", err)
+	// establishing error events buffer
+	errorEventsSize, err := parseErrorEventsSize()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse error events size")
 
-			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not call Could not call cleanup-all-auctions")
+		return
+	}
+	errorEvents = newErrorEventBuffer(errorEventsSize)
 
-			return
-		}
+	// resolving admin token
+	adminToken = os.Getenv("ADMIN_TOKEN")
 
-		w.WriteHeader(http.StatusOK)
-	case "/compute-all-live-auctions":
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not read request body", err)
+	// establishing body read timeout
+	bodyReadTimeout, err = parseBodyReadTimeout()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse body read timeout")
 
-			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not read request body")
+		return
+	}
 
-			return
-		}
+	// establishing max-body limits
+	maxBodyBytes, routeMaxBodyBytes, err = parseMaxBodyBytes()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse max-body limits")
 
-		tuples, err := sotah.NewRegionRealmTimestampTuples(string(body))
-		if err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not decode region-realm-timestamp tuples from request body", err)
+		return
+	}
 
-			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not decode region-realm-timestamp tuples from request body")
+	// establishing error response format
+	problemResponses, err = parseProblemResponses()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse error format")
 
-			return
-		}
+		return
+	}
 
-		if err := state.ComputeAllLiveAuctions(tuples); err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not call compute-all-live-auctions", err)
+	// resolving read-only mode
+	readOnly = os.Getenv("READ_ONLY") == "true"
 
-			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not call compute-all-live-auctions")
+	// establishing gzip level
+	gzipLevel = parseGzipLevel()
 
-			return
-		}
+	// resolving tenants
+	allowedTenants = parseAllowedTenants()
+	requireTenant = os.Getenv("REQUIRE_TENANT") == "true"
 
-		w.WriteHeader(http.StatusCreated)
-	case "/compute-all-pricelist-histories":
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not read request body", err)
+	// establishing admission queue limits
+	maxConcurrency, queueDepth, queueMaxWait, err = parseQueueLimits()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse admission queue limits")
 
-			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not read request body")
+		return
+	}
 
-			return
-		}
+	// establishing partial success status
+	partialSuccessStatus, err = parsePartialSuccessStatus()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse partial success status")
 
-		tuples, err := sotah.NewRegionRealmTimestampTuples(string(body))
-		if err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not decode region-realm-timestamp tuples from request body", err)
+		return
+	}
 
-			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not decode region-realm-timestamp tuples from request body")
+	// establishing debug capture
+	debugCapture = os.Getenv("DEBUG_CAPTURE") == "true"
+	debugCaptureBytes, err = parseDebugCaptureBytes()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse debug capture bytes")
 
-			return
-		}
+		return
+	}
 
-		if err := state.ComputeAllPricelistHistories(tuples); err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not call compute-all-pricelist-histories", err)
+	// establishing cache max ages
+	cacheMaxAges, err = parseCacheMaxAges()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse cache max ages")
 
-			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not call compute-all-pricelist-histories")
+		return
+	}
 
-			return
-		}
+	// establishing download checkpoint cycle
+	downloadCycle, err = parseDownloadCycle()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse download cycle")
 
-		w.WriteHeader(http.StatusCreated)
-	case "/sync-all-items":
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not read request body", err)
+		return
+	}
 
-			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not read request body")
+	// establishing selftest canary realms
+	selftestCanaries, err = parseSelftestCanaries()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse selftest canaries")
 
-			return
-		}
+		return
+	}
 
-		ids, err := blizzard.NewItemIds(string(body))
-		if err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not decode item-ids from request body", err)
+	// establishing integrity sample size
+	integritySampleSize, err = parseIntegritySampleSize()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse integrity sample size")
+
+		return
+	}
+
+	// resolving storage region
+	storageRegion, err = parseStorageRegion()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse storage region")
+
+		return
+	}
+
+	// resolving default region, normalized as tuple regions are
+	defaultRegion = strings.ToLower(strings.TrimSpace(os.Getenv("DEFAULT_REGION")))
+
+	// establishing allowed timestamp skew
+	timestampSkew, err = parseTimestampSkew()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse timestamp skew")
+
+		return
+	}
+
+	// establishing read deadline
+	readDeadline, err = parseReadDeadline()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse read deadline")
 
+		return
+	}
+
+	// establishing cleanup deadline
+	cleanupDeadline, err = parseCleanupDeadline()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse cleanup deadline")
+
+		return
+	}
+
+	// establishing freshness threshold
+	freshnessThreshold, err = parseFreshnessThreshold()
+	if err != nil {
+		logging.WithField("error", err.Error()).Fatal("Could not parse freshness threshold")
+
+		return
+	}
+
+	// adding stackdriver hook
+	logging.WithField("project-id", projectId).Info("Creating stackdriver hook")
+	stackdriverHook, err := stackdriver.NewHook(projectId, serviceName)
+	if err != nil {
+		// refusing to boot only when stackdriver is explicitly required
+		if os.Getenv("REQUIRE_STACKDRIVER") == "true" {
 			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not decode item-ids from request body")
+				"error":     err.Error(),
+				"projectID": projectId,
+			}).Fatal("Could not create new stackdriver logrus hook")
 
 			return
 		}
 
-		if err := state.SyncAllItems(ids); err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not call sync-all-items", err)
+		logging.WithFields(logrus.Fields{
+			"error":     err.Error(),
+			"projectID": projectId,
+		}).Warn("Could not create new stackdriver logrus hook, continuing with console logging only")
+	} else {
+		logging.AddHook(stackdriverHook)
+	}
+
+	// done preliminary setup
+	logging.WithField("service", serviceName).Info("Initializing service")
+
+	// optionally warming up in the background
+	autoWarmup = os.Getenv("AUTO_WARMUP") == "true"
+	if autoWarmup {
+		go warmup()
+	}
+
+	// fin
+	logging.Info("Finished init")
+}
+
+func FnGateway(w http.ResponseWriter, r *http.Request) {
+	infoSampled("Received request")
+
+	logging.WithFields(logrus.Fields{
+		"method":  r.Method,
+		"path":    r.URL.Path,
+		"headers": redactHeaders(r.Header),
+	}).Debug("Request headers")
+
+	if id := requestId(r); id != "" {
+		w.Header().Set(requestIdHeader, id)
+	}
+
+	rt, ok := findRoute(r.URL.Path)
+	if !ok {
+		writeErroneousResponse(w, http.StatusNotFound, "Route not found")
+
+		return
+	}
+	if r.Method != rt.Method {
+		writeErroneousResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+
+		return
+	}
+
+	// keeping every response of mutating routes out of caches
+	if rt.Mutating {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	// attributing the request to a tenant
+	tenant, err := resolveTenant(r)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusBadRequest, err.Error())
 
+		return
+	}
+
+	// refusing mutating routes on read-only deployments
+	if readOnly && rt.Mutating {
+		writeErroneousResponse(w, http.StatusForbidden, "Mutating routes are disabled on this read-only deployment")
+
+		return
+	}
+
+	// waiting for a slot when at max concurrency
+	if !rt.Unqueued {
+		if err := requestQueue.acquire(); err != nil {
 			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not call sync-all-items")
+				"route":  rt.Path,
+				"error":  err.Error(),
+				"tenant": tenant,
+			}).Warn("Rejecting queued request")
+
+			writeErroneousResponse(w, http.StatusServiceUnavailable, err.Error())
 
 			return
 		}
+		defer requestQueue.release()
+	}
 
-		w.WriteHeader(http.StatusCreated)
-	case "/cleanup-all-pricelist-histories":
-		if err := state.CleanupAllPricelistHistories(); err != nil {
-			act.WriteErroneousErrorResponse(w, "Could not call cleanup-all-pricelist-histories", err)
-
-			logging.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Could not call Could not call cleanup-all-pricelist-histories")
+	// resolving gateway state
+	var sta fn.GatewayState
+	if rt.RequiresState {
+		sta, err = resolveState()
+		if err != nil {
+			writeErroneousResponse(w, http.StatusServiceUnavailable, "Could not generate fn-gateway state")
 
 			return
 		}
+	}
+
+	// blocking routes whose own dependencies are known to be down
+	if down := openDependencies(rt.Dependencies); len(down) > 0 {
+		logging.WithFields(logrus.Fields{
+			"route":        rt.Path,
+			"dependencies": down,
+			"tenant":       tenant,
+		}).Warn("Rejecting request as dependencies are down")
 
-		w.WriteHeader(http.StatusOK)
+		writeErroneousResponse(w, http.StatusServiceUnavailable, "Route dependencies are unavailable")
+
+		return
 	}
 
-	logging.Info("Sent response")
+	// recording server-side errors, setting cache headers, and auditing and tracking mutating routes
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK, cacheControl: cacheControlFor(rt)}
+	w = recorder
+	defer recordErrorEvent(r, rt.Path, recorder)
+	var audit *auditEntry
+	if rt.Mutating {
+		audit = newAuditEntry(r)
+		audit.Tenant = tenant
+		defer recordAuditEntry(sta, audit, recorder)
+
+		op := startOperation(r, rt.Path)
+		defer op.finish(audit, recorder)
+	}
+
+	// metering the request body as it is read, up to the route's max-body
+	body := newMeteredBody(r.Body, maxBodyFor(rt.Path))
+	r.Body = body
+	defer body.report(rt.Path)
+
+	// optionally keeping the exchange for /admin/last
+	if debugCapture && rt.Path != "/admin/last" {
+		recorder.captureLimit = debugCaptureBytes
+		captured := &capturedBody{ReadCloser: r.Body}
+		r.Body = captured
+		defer captureExchange(r, rt.Path, captured, recorder)
+	}
+
+	rt.handler(w, r, sta, audit)
+
+	// the access line is never sampled, only the received line is
+	logging.WithFields(logrus.Fields{
+		"route":  rt.Path,
+		"status": recorder.status,
+		"tenant": tenant,
+	}).Info("Sent response")
 }
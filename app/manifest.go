@@ -0,0 +1,226 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah/gameversions"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/store"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/util"
+)
+
+// manifestReadWorkers bounds how many manifests are read at once
+const manifestReadWorkers = 8
+
+// manifestMatchTolerance is how far a tuple's timestamp may drift from a stored manifest timestamp and still be
+// matched to it, where zero requires an exact match
+var manifestMatchTolerance time.Duration
+
+func parseManifestMatchTolerance() (time.Duration, error) {
+	provided := os.Getenv("MANIFEST_MATCH_TOLERANCE_SECONDS")
+	if provided == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 0 {
+		return 0, errors.New("manifest match tolerance seconds must not be negative")
+	}
+
+	return time.Duration(parsed) * time.Second, nil
+}
+
+// manifestKey is a realm's auction manifest for a day, as manifests are stored per realm under the start of the day
+// and list every timestamp downloaded that day
+type manifestKey struct {
+	RegionName blizzard.RegionName
+	RealmSlug  blizzard.RealmSlug
+	Day        sotah.UnixTimestamp
+}
+
+func newManifestKey(tuple sotah.RegionRealmTimestampTuple, timestamp int) manifestKey {
+	return manifestKey{
+		RegionName: blizzard.RegionName(tuple.RegionName),
+		RealmSlug:  blizzard.RealmSlug(tuple.RealmSlug),
+		Day:        sotah.UnixTimestamp(sotah.NormalizeTargetDate(time.Unix(int64(timestamp), 0)).Unix()),
+	}
+}
+
+type manifestReadJob struct {
+	key      manifestKey
+	manifest sotah.AuctionManifest
+	err      error
+}
+
+// readManifests reads each of the given manifests once, a bounded number at a time, where a manifest that is not
+// stored reads as empty
+func readManifests(sta fn.GatewayState, keys []manifestKey) (map[manifestKey]sotah.AuctionManifest, error) {
+	manifestBase := store.NewAuctionManifestBaseV2(sta.IO.StoreClient, storageRegion, gameversions.Retail)
+	bkt, err := manifestBase.GetFirmBucket()
+	if err != nil {
+		return map[manifestKey]sotah.AuctionManifest{}, err
+	}
+
+	// establishing channels
+	in := make(chan manifestKey)
+	out := make(chan manifestReadJob)
+
+	// spinning up the workers
+	worker := func() {
+		for key := range in {
+			realm := sotah.NewSkeletonRealm(key.RegionName, key.RealmSlug)
+			manifest, err := readManifest(sta, manifestBase.GetObject(key.Day, realm, bkt))
+			out <- manifestReadJob{key: key, manifest: manifest, err: err}
+		}
+	}
+	postWork := func() {
+		close(out)
+	}
+	util.Work(manifestReadWorkers, worker, postWork)
+
+	// queueing up the manifests
+	go func() {
+		for _, key := range keys {
+			in <- key
+		}
+
+		close(in)
+	}()
+
+	manifests := map[manifestKey]sotah.AuctionManifest{}
+	var firstErr error
+	for job := range out {
+		if job.err != nil {
+			if firstErr == nil {
+				firstErr = job.err
+			}
+
+			continue
+		}
+
+		manifests[job.key] = job.manifest
+	}
+	if firstErr != nil {
+		return map[manifestKey]sotah.AuctionManifest{}, firstErr
+	}
+
+	return manifests, nil
+}
+
+func readManifest(sta fn.GatewayState, obj *storage.ObjectHandle) (sotah.AuctionManifest, error) {
+	reader, err := obj.NewReader(sta.IO.StoreClient.Context)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return sotah.AuctionManifest{}, nil
+		}
+
+		return sotah.AuctionManifest{}, err
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return sotah.AuctionManifest{}, err
+	}
+
+	var out sotah.AuctionManifest
+	if err := json.Unmarshal(data, &out); err != nil {
+		return sotah.AuctionManifest{}, err
+	}
+
+	return out, nil
+}
+
+// manifestKeysInRange lists the manifests that may hold a timestamp within tolerance of the tuple, which spans
+// more than one day when the window crosses midnight
+func manifestKeysInRange(tuple sotah.RegionRealmTimestampTuple, tolerance int) []manifestKey {
+	out := []manifestKey{}
+	last := newManifestKey(tuple, tuple.TargetTimestamp+tolerance)
+	for key := newManifestKey(tuple, tuple.TargetTimestamp-tolerance); key.Day <= last.Day; {
+		out = append(out, key)
+		key = newManifestKey(tuple, int(key.Day)+int(24*time.Hour/time.Second))
+	}
+
+	return out
+}
+
+// matchManifestTuples matches each tuple to the nearest timestamp listed in its realm's auction manifests within the
+// tolerance, handing back separately the tuples with none in range, as there is no data to compute them from
+// each realm's manifest for a day is read once, however many tuples fall on that day, and tuples matched onto the
+// same timestamp are only kept once
+func matchManifestTuples(
+	sta fn.GatewayState,
+	tuples sotah.RegionRealmTimestampTuples,
+) (sotah.RegionRealmTimestampTuples, sotah.RegionRealmTimestampTuples, error) {
+	tolerance := int(manifestMatchTolerance / time.Second)
+
+	keys := []manifestKey{}
+	seen := map[manifestKey]struct{}{}
+	for _, tuple := range tuples {
+		for _, key := range manifestKeysInRange(tuple, tolerance) {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+
+	manifests, err := readManifests(sta, keys)
+	if err != nil {
+		return sotah.RegionRealmTimestampTuples{}, sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	matched := sotah.RegionRealmTimestampTuples{}
+	missing := sotah.RegionRealmTimestampTuples{}
+	wasMatched := map[sotah.RegionRealmTimestampTuple]struct{}{}
+	for _, tuple := range tuples {
+		nearest, found := 0, false
+		for _, key := range manifestKeysInRange(tuple, tolerance) {
+			for _, timestamp := range manifests[key] {
+				drift := absInt(int(timestamp) - tuple.TargetTimestamp)
+				if drift > tolerance {
+					continue
+				}
+				if !found || drift < absInt(nearest-tuple.TargetTimestamp) {
+					nearest, found = int(timestamp), true
+				}
+			}
+		}
+		if !found {
+			missing = append(missing, tuple)
+
+			continue
+		}
+
+		tuple.TargetTimestamp = nearest
+		if _, ok := wasMatched[tuple]; ok {
+			continue
+		}
+
+		wasMatched[tuple] = struct{}{}
+		matched = append(matched, tuple)
+	}
+
+	return matched, missing, nil
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
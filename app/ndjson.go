@@ -0,0 +1,89 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// maxSyncItems caps how many item-ids one sync request may carry, where zero disables the cap
+var maxSyncItems int
+
+func parseMaxSyncItems() (int, error) {
+	provided := os.Getenv("SYNC_MAX_ITEMS")
+	if provided == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 0 {
+		return 0, errors.New("sync max items must not be negative")
+	}
+
+	return parsed, nil
+}
+
+var errTooManyItemIds = errors.New("request body contains too many item-ids")
+
+func isNDJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	return err == nil && mediaType == ndjsonContentType
+}
+
+type ndjsonItemId struct {
+	Id blizzard.ItemID `json:"id"`
+}
+
+// decodeNDJSONItemIds reads one item-id per line, either bare or as {"id": ...}, enforcing the max as lines are read
+func decodeNDJSONItemIds(body io.Reader) (blizzard.ItemIds, error) {
+	out := blizzard.ItemIds{}
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if maxSyncItems > 0 && len(out) >= maxSyncItems {
+			return blizzard.ItemIds{}, errTooManyItemIds
+		}
+
+		// accepting small objects alongside bare item-ids
+		if line[0] == '{' {
+			var obj ndjsonItemId
+			if err := json.Unmarshal(line, &obj); err != nil {
+				return blizzard.ItemIds{}, err
+			}
+
+			out = append(out, obj.Id)
+
+			continue
+		}
+
+		id, err := strconv.Atoi(string(line))
+		if err != nil {
+			return blizzard.ItemIds{}, err
+		}
+
+		out = append(out, blizzard.ItemID(id))
+	}
+	if err := scanner.Err(); err != nil {
+		return blizzard.ItemIds{}, err
+	}
+
+	return out, nil
+}
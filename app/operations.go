@@ -0,0 +1,53 @@
+package app
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+)
+
+// operationsInFlight gauges the mutating routes currently running on this instance
+var operationsInFlight int64
+
+// operation tracks a mutating route from start to finish
+type operation struct {
+	route     string
+	requestId string
+	started   time.Time
+}
+
+// startOperation logs that a mutating route has started, ahead of its body being read
+func startOperation(r *http.Request, routePath string) operation {
+	op := operation{route: routePath, requestId: requestId(r), started: time.Now()}
+
+	logging.WithFields(logrus.Fields{
+		"event":                "operation_started",
+		"route":                op.route,
+		"request-id":           op.requestId,
+		"operations-in-flight": atomic.AddInt64(&operationsInFlight, 1),
+	}).Info("Operation started")
+
+	return op
+}
+
+// finish logs the duration and outcome of the operation, along with the scope size resolved by its route
+func (op operation) finish(audit *auditEntry, recorder *statusRecorder) {
+	outcome := "succeeded"
+	if recorder.status >= http.StatusBadRequest {
+		outcome = "failed"
+	}
+
+	logging.WithFields(logrus.Fields{
+		"event":                "operation_finished",
+		"route":                op.route,
+		"request-id":           op.requestId,
+		"scope-size":           audit.ScopeSize,
+		"status":               recorder.status,
+		"outcome":              outcome,
+		"duration-in-ms":       int64(time.Since(op.started) / time.Millisecond),
+		"operations-in-flight": atomic.AddInt64(&operationsInFlight, -1),
+	}).Info("Operation finished")
+}
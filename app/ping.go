@@ -0,0 +1,117 @@
+package app
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/subjects"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/store"
+)
+
+type dependencyName string
+
+const (
+	storageDependency dependencyName = "storage"
+	hellDependency    dependencyName = "hell"
+	busDependency     dependencyName = "bus"
+)
+
+type pingFunc func(sta fn.GatewayState) error
+
+// dependencyPings are ordered so that the ping response is stable
+var dependencyPings = []struct {
+	name dependencyName
+	ping pingFunc
+}{
+	{storageDependency, pingStorage},
+	{hellDependency, pingHell},
+	{busDependency, pingBus},
+}
+
+func pingStorage(sta fn.GatewayState) error {
+	bootBase := store.NewBootBase(sta.IO.StoreClient, storageRegion)
+	exists, err := bootBase.BucketExists(bootBase.GetBucket())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("boot bucket does not exist")
+	}
+
+	return nil
+}
+
+func pingHell(sta fn.GatewayState) error {
+	_, err := sta.IO.HellClient.GetActEndpoints()
+
+	return err
+}
+
+func pingBus(sta fn.GatewayState) error {
+	_, err := sta.IO.BusClient.FirmTopic(string(subjects.ReceiveRealms))
+
+	return err
+}
+
+type dependencyPingResult struct {
+	Name      dependencyName `json:"name"`
+	Reachable bool           `json:"reachable"`
+	LatencyMs int64          `json:"latency_ms"`
+	Error     string         `json:"error,omitempty"`
+}
+
+type pingResponse struct {
+	Reachable    bool                   `json:"reachable"`
+	Dependencies []dependencyPingResult `json:"dependencies"`
+}
+
+// pingDependencies checks connectivity to each of the given downstreams in turn, all of which are critical
+// each result trips or resets the dependency's breaker
+func pingDependencies(sta fn.GatewayState, names []dependencyName) pingResponse {
+	out := pingResponse{Reachable: true, Dependencies: []dependencyPingResult{}}
+	for _, dependency := range dependencyPings {
+		if !containsDependency(names, dependency.name) {
+			continue
+		}
+
+		startTime := time.Now()
+		err := dependency.ping(sta)
+
+		result := dependencyPingResult{
+			Name:      dependency.name,
+			Reachable: err == nil,
+			LatencyMs: int64(time.Since(startTime) / time.Millisecond),
+		}
+		if err != nil {
+			result.Error = err.Error()
+			out.Reachable = false
+			tripBreaker(dependency.name)
+		} else {
+			resetBreaker(dependency.name)
+		}
+
+		out.Dependencies = append(out.Dependencies, result)
+	}
+
+	return out
+}
+
+func allDependencies() []dependencyName {
+	out := []dependencyName{}
+	for _, dependency := range dependencyPings {
+		out = append(out, dependency.name)
+	}
+
+	return out
+}
+
+func containsDependency(names []dependencyName, name dependencyName) bool {
+	for _, candidate := range names {
+		if candidate == name {
+			return true
+		}
+	}
+
+	return false
+}
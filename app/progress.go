@@ -0,0 +1,105 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+)
+
+// tupleProgress is the outcome of a single tuple, reported as soon as its act call completes
+type tupleProgress struct {
+	sotah.RegionRealmTimestampTuple
+	Outcome string `json:"outcome"`
+
+	// computeFailure is why the tuple was not computed, when it failed
+	*computeFailure
+}
+
+// progressFunc receives the outcome of each tuple as it completes, where a nil progressFunc ignores them
+type progressFunc func(progress tupleProgress)
+
+func (progress progressFunc) succeeded(tuple sotah.RegionRealmTimestampTuple) {
+	if progress == nil {
+		return
+	}
+
+	progress(tupleProgress{RegionRealmTimestampTuple: tuple, Outcome: "succeeded"})
+}
+
+func (progress progressFunc) failed(tuple sotah.RegionRealmTimestampTuple, reason failureReason) {
+	if progress == nil {
+		return
+	}
+
+	progress(tupleProgress{
+		RegionRealmTimestampTuple: tuple,
+		Outcome:                   "failed",
+		computeFailure:            &computeFailure{Reason: reason, Retryable: reason.retryable()},
+	})
+}
+
+// progressStream writes each tuple's outcome as a newline-delimited json line, flushing it straight away
+// the status is committed as the stream opens, so the outcome of the whole batch is only known from the last line
+type progressStream struct {
+	w       http.ResponseWriter
+	encoder *json.Encoder
+}
+
+// acceptsProgressStream is whether the client asked for per-tuple progress over newline-delimited json
+func acceptsProgressStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+func newProgressStream(w http.ResponseWriter) *progressStream {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+
+	return &progressStream{w: w, encoder: json.NewEncoder(w)}
+}
+
+func (stream *progressStream) write(v interface{}) {
+	if err := stream.encoder.Encode(v); err != nil {
+		logging.WithField("error", err.Error()).Error("Failed to write progress line")
+
+		return
+	}
+
+	if flusher, ok := stream.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// progress hands back a progressFunc writing to the stream, or nil when there is no stream
+func (stream *progressStream) progress() progressFunc {
+	if stream == nil {
+		return nil
+	}
+
+	return func(progress tupleProgress) {
+		stream.write(progress)
+	}
+}
+
+// collectFailures wraps a progressFunc, keeping hold of why each failed tuple failed
+func collectFailures(
+	next progressFunc,
+) (progressFunc, map[sotah.RegionRealmTimestampTuple]computeFailure) {
+	failures := map[sotah.RegionRealmTimestampTuple]computeFailure{}
+
+	return func(progress tupleProgress) {
+		if progress.computeFailure != nil {
+			failures[progress.RegionRealmTimestampTuple] = *progress.computeFailure
+		}
+
+		if next != nil {
+			next(progress)
+		}
+	}, failures
+}
+
+type progressError struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"container/list"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxConcurrency is the number of requests handled at once, where zero disables queuing
+// the queue only takes effect on runtimes serving concurrent requests per instance, gen1 go111 serves one at a time
+var maxConcurrency int
+
+// queueDepth and queueMaxWait bound how many requests may wait for a slot, and for how long
+var queueDepth = 10
+var queueMaxWait = 10 * time.Second
+
+func parseQueueLimits() (int, int, time.Duration, error) {
+	concurrency := 0
+	if provided := os.Getenv("MAX_CONCURRENCY"); provided != "" {
+		parsed, err := strconv.Atoi(provided)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if parsed < 0 {
+			return 0, 0, 0, errors.New("max concurrency must not be negative")
+		}
+
+		concurrency = parsed
+	}
+
+	depth := 10
+	if provided := os.Getenv("QUEUE_DEPTH"); provided != "" {
+		parsed, err := strconv.Atoi(provided)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if parsed < 0 {
+			return 0, 0, 0, errors.New("queue depth must not be negative")
+		}
+
+		depth = parsed
+	}
+
+	maxWait := 10 * time.Second
+	if provided := os.Getenv("QUEUE_MAX_WAIT_SECONDS"); provided != "" {
+		parsed, err := strconv.Atoi(provided)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if parsed <= 0 {
+			return 0, 0, 0, errors.New("queue max wait seconds must be positive")
+		}
+
+		maxWait = time.Duration(parsed) * time.Second
+	}
+
+	return concurrency, depth, maxWait, nil
+}
+
+var errQueueFull = errors.New("admission queue is full")
+var errQueueWait = errors.New("timed out waiting in admission queue")
+
+// admissionQueue admits requests up to the max concurrency, queueing the rest in arrival order
+// a freed slot is handed directly to the longest waiting request, so that newcomers cannot jump the queue
+type admissionQueue struct {
+	sync.Mutex
+
+	active  int
+	waiters *list.List
+
+	peakDepth int
+	waited    int
+	totalWait time.Duration
+}
+
+var requestQueue = &admissionQueue{waiters: list.New()}
+
+func (q *admissionQueue) acquire() error {
+	if maxConcurrency == 0 {
+		return nil
+	}
+
+	q.Lock()
+	if q.active < maxConcurrency && q.waiters.Len() == 0 {
+		q.active++
+		q.Unlock()
+
+		return nil
+	}
+	if q.waiters.Len() >= queueDepth {
+		q.Unlock()
+
+		return errQueueFull
+	}
+	ready := make(chan struct{})
+	waiter := q.waiters.PushBack(ready)
+	if q.waiters.Len() > q.peakDepth {
+		q.peakDepth = q.waiters.Len()
+	}
+	q.Unlock()
+
+	startTime := time.Now()
+	defer q.recordWait(startTime)
+
+	timer := time.NewTimer(queueMaxWait)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		q.Lock()
+		defer q.Unlock()
+
+		// a slot may have been handed over while timing out
+		select {
+		case <-ready:
+			return nil
+		default:
+		}
+
+		q.waiters.Remove(waiter)
+
+		return errQueueWait
+	}
+}
+
+func (q *admissionQueue) recordWait(startTime time.Time) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.waited++
+	q.totalWait += time.Since(startTime)
+}
+
+func (q *admissionQueue) release() {
+	if maxConcurrency == 0 {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if front := q.waiters.Front(); front != nil {
+		q.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+
+		return
+	}
+
+	q.active--
+}
+
+type queueStats struct {
+	Active        int   `json:"active"`
+	Depth         int   `json:"depth"`
+	PeakDepth     int   `json:"peak_depth"`
+	Waited        int   `json:"waited"`
+	AverageWaitMs int64 `json:"average_wait_ms"`
+}
+
+// stats reports the queue as it stands, where waits cover every queued request whether admitted or timed out
+func (q *admissionQueue) stats() queueStats {
+	q.Lock()
+	defer q.Unlock()
+
+	out := queueStats{Active: q.active, Depth: q.waiters.Len(), PeakDepth: q.peakDepth, Waited: q.waited}
+	if q.waited > 0 {
+		out.AverageWaitMs = int64(q.totalWait / time.Duration(q.waited) / time.Millisecond)
+	}
+
+	return out
+}
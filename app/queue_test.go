@@ -0,0 +1,92 @@
+package app
+
+import (
+	"container/list"
+	"testing"
+	"time"
+)
+
+// withQueueLimits swaps in the queue limits, returning a func that restores the previous ones
+func withQueueLimits(concurrency int, depth int, maxWait time.Duration) func() {
+	previousConcurrency, previousDepth, previousMaxWait := maxConcurrency, queueDepth, queueMaxWait
+	maxConcurrency, queueDepth, queueMaxWait = concurrency, depth, maxWait
+
+	return func() {
+		maxConcurrency, queueDepth, queueMaxWait = previousConcurrency, previousDepth, previousMaxWait
+	}
+}
+
+func TestAdmissionQueueAcquire(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		depth       int
+		held        int
+		want        error
+	}{
+		{name: "queuing disabled", concurrency: 0, depth: 0, held: 5, want: nil},
+		{name: "free slot", concurrency: 2, depth: 1, held: 1, want: nil},
+		{name: "queue full", concurrency: 1, depth: 0, held: 1, want: errQueueFull},
+		{name: "timed out waiting", concurrency: 1, depth: 1, held: 1, want: errQueueWait},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer withQueueLimits(test.concurrency, test.depth, 10*time.Millisecond)()
+
+			q := &admissionQueue{waiters: list.New()}
+			for i := 0; i < test.held; i++ {
+				if err := q.acquire(); err != nil {
+					t.Fatalf("unexpected error holding slot %d: %s", i, err.Error())
+				}
+			}
+
+			if err := q.acquire(); err != test.want {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+			if test.want == errQueueWait && q.stats().Depth != 0 {
+				t.Errorf("timed out waiter was left in the queue")
+			}
+		})
+	}
+}
+
+func TestAdmissionQueueRelease(t *testing.T) {
+	defer withQueueLimits(1, 2, time.Second)()
+
+	q := &admissionQueue{waiters: list.New()}
+	if err := q.acquire(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// queuing two waiters, which are handed the slot in arrival order
+	admitted := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			if err := q.acquire(); err != nil {
+				t.Errorf("waiter %d: unexpected error: %s", i, err.Error())
+
+				return
+			}
+
+			admitted <- i
+		}(i)
+
+		for q.stats().Depth != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for want := 0; want < 2; want++ {
+		q.release()
+		if got := <-admitted; got != want {
+			t.Errorf("got waiter %d admitted, want %d", got, want)
+		}
+	}
+
+	// releasing the last slot with no waiters frees it
+	q.release()
+	if stats := q.stats(); stats.Active != 0 || stats.Depth != 0 {
+		t.Errorf("got %+v, want no active or waiting requests", stats)
+	}
+}
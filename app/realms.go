@@ -0,0 +1,143 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah/gameversions"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/store"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/store/regions"
+)
+
+// storageRegion is the location of the buckets the gateway reads and writes itself
+var storageRegion = regions.USCentral1
+
+// knownStorageRegions are the regions STORAGE_REGION may name
+// fn.GatewayState reads and writes us-central1 regardless, so any other region would split the gateway's own reads
+// from the state's, and is refused until the state accepts a region
+var knownStorageRegions = map[regions.Region]struct{}{
+	regions.USCentral1: {},
+}
+
+func parseStorageRegion() (regions.Region, error) {
+	provided := strings.TrimSpace(os.Getenv("STORAGE_REGION"))
+	if provided == "" {
+		return regions.USCentral1, nil
+	}
+
+	if _, ok := knownStorageRegions[regions.Region(provided)]; !ok {
+		return "", fmt.Errorf("storage region %s is not one of the regions the gateway state stores to", provided)
+	}
+
+	return regions.Region(provided), nil
+}
+
+// mismatchCatalogTTL is how long the realm catalog used by the compute mismatch check is kept before it is read
+// again, bounding how long a roster change goes unseen by that check alone
+const mismatchCatalogTTL = 5 * time.Minute
+
+var mismatchCatalog sotah.RegionRealms
+var mismatchCatalogReadAt time.Time
+var mismatchCatalogMutex sync.Mutex
+
+// getAllRegionRealms gathers the realms of every region the same way the gateway state does for its all-realms calls,
+// reading them fresh on every call so that roster changes are picked up without a redeploy
+func getAllRegionRealms(sta fn.GatewayState) (sotah.RegionRealms, error) {
+	return readAllRegionRealms(sta)
+}
+
+// getMismatchCatalog hands back the realm catalog for the compute mismatch check, which runs on every compute call
+// and so reads the catalog at most once per mismatchCatalogTTL, where a failed read is retried by the next caller
+// callers must not modify the realms handed back, as they are shared
+func getMismatchCatalog(sta fn.GatewayState) (sotah.RegionRealms, error) {
+	mismatchCatalogMutex.Lock()
+	defer mismatchCatalogMutex.Unlock()
+
+	if mismatchCatalog != nil && time.Since(mismatchCatalogReadAt) < mismatchCatalogTTL {
+		return mismatchCatalog, nil
+	}
+
+	regionRealms, err := readAllRegionRealms(sta)
+	if err != nil {
+		return sotah.RegionRealms{}, err
+	}
+
+	mismatchCatalog = regionRealms
+	mismatchCatalogReadAt = time.Now()
+
+	return mismatchCatalog, nil
+}
+
+func readAllRegionRealms(sta fn.GatewayState) (sotah.RegionRealms, error) {
+	// gathering regions from boot-bucket
+	bootBase := store.NewBootBase(sta.IO.StoreClient, storageRegion)
+	regionList, err := bootBase.GetRegions(bootBase.GetBucket())
+	if err != nil {
+		return sotah.RegionRealms{}, err
+	}
+
+	// gathering realms for each region from the realms base
+	realmsBase := store.NewRealmsBase(sta.IO.StoreClient, storageRegion, gameversions.Retail)
+	realmsBucket := realmsBase.GetBucket()
+	regionRealms := sotah.RegionRealms{}
+	for _, region := range regionList {
+		realms, err := realmsBase.GetAllRealms(region.Name, realmsBucket)
+		if err != nil {
+			return sotah.RegionRealms{}, err
+		}
+
+		regionRealms[region.Name] = realms
+	}
+
+	return regionRealms, nil
+}
+
+type mismatchedTuple struct {
+	sotah.RegionRealmTimestampTuple
+	KnownRegions []blizzard.RegionName `json:"known_regions"`
+}
+
+// mismatchedTuples gathers the tuples whose realm does not belong to their region, along with the regions it does
+// belong to, if any
+func mismatchedTuples(sta fn.GatewayState, tuples sotah.RegionRealmTimestampTuples) ([]mismatchedTuple, error) {
+	regionRealms, err := getMismatchCatalog(sta)
+	if err != nil {
+		return []mismatchedTuple{}, err
+	}
+
+	realmRegions := map[blizzard.RealmSlug][]blizzard.RegionName{}
+	for regionName, realms := range regionRealms {
+		for _, realm := range realms {
+			realmRegions[realm.Slug] = append(realmRegions[realm.Slug], regionName)
+		}
+	}
+
+	out := []mismatchedTuple{}
+	for _, tuple := range tuples {
+		knownRegions := realmRegions[blizzard.RealmSlug(tuple.RealmSlug)]
+		matched := false
+		for _, regionName := range knownRegions {
+			if regionName == blizzard.RegionName(tuple.RegionName) {
+				matched = true
+
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		if knownRegions == nil {
+			knownRegions = []blizzard.RegionName{}
+		}
+		out = append(out, mismatchedTuple{RegionRealmTimestampTuple: tuple, KnownRegions: knownRegions})
+	}
+
+	return out, nil
+}
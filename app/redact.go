@@ -0,0 +1,51 @@
+package app
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+const redactedValue = "***"
+
+// redactedHeaders are the canonical names of headers whose values must never be logged
+var redactedHeaders = map[string]struct{}{
+	"Authorization": {},
+	"Cookie":        {},
+	"X-Admin-Token": {},
+}
+
+// parseRedactedHeaders adds the comma-separated REDACT_HEADERS to the default redacted headers
+func parseRedactedHeaders() map[string]struct{} {
+	out := map[string]struct{}{}
+	for name := range redactedHeaders {
+		out[name] = struct{}{}
+	}
+
+	for _, name := range strings.Split(os.Getenv("REDACT_HEADERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		out[http.CanonicalHeaderKey(name)] = struct{}{}
+	}
+
+	return out
+}
+
+// redactHeaders copies the headers with sensitive values replaced, for use ahead of any logging
+func redactHeaders(headers http.Header) http.Header {
+	out := http.Header{}
+	for name, values := range headers {
+		if _, ok := redactedHeaders[http.CanonicalHeaderKey(name)]; ok {
+			out[name] = []string{redactedValue}
+
+			continue
+		}
+
+		out[name] = values
+	}
+
+	return out
+}
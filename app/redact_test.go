@@ -0,0 +1,71 @@
+package app
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers http.Header
+		want    http.Header
+	}{
+		{
+			name:    "empty",
+			headers: http.Header{},
+			want:    http.Header{},
+		},
+		{
+			name: "sensitive values redacted",
+			headers: http.Header{
+				"Authorization": []string{"Bearer secret"},
+				"X-Admin-Token": []string{"secret", "another"},
+				"Content-Type":  []string{"application/json"},
+			},
+			want: http.Header{
+				"Authorization": []string{redactedValue},
+				"X-Admin-Token": []string{redactedValue},
+				"Content-Type":  []string{"application/json"},
+			},
+		},
+		{
+			name:    "non-canonical names redacted",
+			headers: http.Header{"cookie": []string{"session=secret"}},
+			want:    http.Header{"cookie": []string{redactedValue}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := redactHeaders(test.headers)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactHeadersLeavesHeadersUntouched(t *testing.T) {
+	headers := http.Header{"Authorization": []string{"Bearer secret"}}
+	redactHeaders(headers)
+
+	if headers.Get("Authorization") != "Bearer secret" {
+		t.Errorf("headers were redacted in place")
+	}
+}
+
+func TestParseRedactedHeaders(t *testing.T) {
+	defer withEnv(map[string]string{"REDACT_HEADERS": " x-api-key ,, X-Other "})()
+
+	got := parseRedactedHeaders()
+	for _, name := range []string{"Authorization", "Cookie", "X-Admin-Token", "X-Api-Key", "X-Other"} {
+		if _, ok := got[name]; !ok {
+			t.Errorf("%s was not redacted", name)
+		}
+	}
+	if len(got) != 5 {
+		t.Errorf("got %d redacted headers, want 5", len(got))
+	}
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+)
+
+// storedAuctionsExist checks that the raw auctions a tuple identifies are still stored, as live auctions
+// are computed from the stored auctions at the tuple's timestamp
+func storedAuctionsExist(sta fn.GatewayState, tuple sotah.RegionRealmTimestampTuple) (bool, error) {
+	auctionsBase := newAuctionsBase(sta)
+	bkt, err := auctionsBase.GetFirmBucket()
+	if err != nil {
+		return false, err
+	}
+
+	realm := sotah.NewSkeletonRealm(blizzard.RegionName(tuple.RegionName), blizzard.RealmSlug(tuple.RealmSlug))
+
+	return auctionsBase.ObjectExists(auctionsBase.GetObject(realm, time.Unix(int64(tuple.TargetTimestamp), 0), bkt))
+}
+
+func handleReplayLiveAuctions(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	var tuple sotah.RegionRealmTimestampTuple
+	if err := json.NewDecoder(r.Body).Decode(&tuple); err != nil {
+		if !writeBodyReadError(w, err) {
+			writeErroneousResponse(w, http.StatusBadRequest, "Could not decode region-realm-timestamp tuple from request body")
+		}
+
+		logging.WithField("error", err.Error()).Error("Could not decode region-realm-timestamp tuple from request body")
+
+		return
+	}
+	tuple = normalizeTuple(tuple)
+
+	tuples := sotah.RegionRealmTimestampTuples{tuple}
+	audit.setTupleScope(tuples)
+
+	// checking that the auctions to replay from are still stored
+	exists, err := storedAuctionsExist(sta, tuple)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not check stored auctions")
+
+		logging.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"region": tuple.RegionName,
+			"realm":  tuple.RealmSlug,
+		}).Error("Could not check stored auctions")
+
+		return
+	}
+	if !exists {
+		writeErroneousResponse(w, http.StatusNotFound, "No stored auctions for region-realm-timestamp tuple")
+
+		return
+	}
+
+	logging.WithFields(logrus.Fields{
+		"region":           tuple.RegionName,
+		"realm":            tuple.RealmSlug,
+		"target-timestamp": tuple.TargetTimestamp,
+	}).Info("Replaying live-auctions")
+
+	computed, err := liveAuctionsTarget.compute(sta, tuples, nil)
+	if err != nil {
+		recordComputeOutcomes(sta, liveAuctionsTarget, sotah.RegionRealmTimestampTuples{}, tuples, err.Error())
+
+		if writeContextError(w, r, err) {
+			return
+		}
+
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not replay live-auctions")
+
+		logging.WithField("error", err.Error()).Error("Could not replay live-auctions")
+
+		return
+	}
+	if len(computed) == 0 {
+		recordComputeOutcomes(sta, liveAuctionsTarget, computed, tuples, "tuple was not computed")
+
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not replay live-auctions")
+
+		return
+	}
+	recordComputeOutcomes(sta, liveAuctionsTarget, computed, sotah.RegionRealmTimestampTuples{}, "")
+
+	w.WriteHeader(http.StatusCreated)
+}
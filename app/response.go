@@ -0,0 +1,148 @@
+package app
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+)
+
+// problemResponses is whether erroneous responses are written as rfc 7807 problem details
+var problemResponses bool
+
+func parseProblemResponses() (bool, error) {
+	switch os.Getenv("ERROR_FORMAT") {
+	case "", "plain":
+		return false, nil
+	case "problem":
+		return true, nil
+	default:
+		return false, errors.New("error format must be one of plain or problem")
+	}
+}
+
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// writeProblem writes an rfc 7807 problem, where the instance is the request-id already set on the response
+func writeProblem(w http.ResponseWriter, code int, detail string) {
+	jsonEncoded, err := json.Marshal(problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(code),
+		Status:   code,
+		Detail:   detail,
+		Instance: w.Header().Get(requestIdHeader),
+	})
+	if err != nil {
+		logging.WithField("error", err.Error()).Error("Could not encode problem")
+
+		w.WriteHeader(code)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(code)
+
+	if _, err := w.Write(jsonEncoded); err != nil {
+		logging.WithField("error", err.Error()).Error("Failed to write response")
+	}
+}
+
+// writeErroneousResponse writes the status code ahead of the body, so that the code is not discarded
+func writeErroneousResponse(w http.ResponseWriter, code int, responseBody string) {
+	if problemResponses {
+		writeProblem(w, code, responseBody)
+
+		return
+	}
+
+	w.WriteHeader(code)
+
+	if _, err := w.Write([]byte(responseBody)); err != nil {
+		logging.WithField("error", err.Error()).Error("Failed to write response")
+	}
+}
+
+// statusClientClosedRequest is the non-standard status for requests abandoned by the client
+const statusClientClosedRequest = 499
+
+// writeContextError answers failures caused by the request context ending, flagging whether it did so
+// canceled requests get no body as the client has already gone, and are not logged as errors
+func writeContextError(w http.ResponseWriter, r *http.Request, err error) bool {
+	ctxErr := r.Context().Err()
+	switch {
+	case err == context.Canceled || ctxErr == context.Canceled:
+		logging.WithField("error", err.Error()).Info("Client closed request")
+
+		w.WriteHeader(statusClientClosedRequest)
+	case err == context.DeadlineExceeded || ctxErr == context.DeadlineExceeded:
+		logging.WithField("error", err.Error()).Warn("Request deadline exceeded")
+
+		writeErroneousResponse(w, http.StatusGatewayTimeout, "Request deadline exceeded")
+	default:
+		return false
+	}
+
+	return true
+}
+
+func writeJSONResponse(w http.ResponseWriter, code int, v interface{}) {
+	jsonEncoded, err := json.Marshal(v)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not encode response")
+
+		logging.WithField("error", err.Error()).Error("Could not encode response")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if _, err := w.Write(jsonEncoded); err != nil {
+		logging.WithField("error", err.Error()).Error("Failed to write response")
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+}
+
+const requestIdHeader = "X-Request-Id"
+
+// requestId resolves the id the functions platform assigned to the request's execution
+func requestId(r *http.Request) string {
+	return r.Header.Get("Function-Execution-Id")
+}
+
+// gzipLevel is the compression level of gzip-encoded responses
+var gzipLevel = gzip.DefaultCompression
+
+// parseGzipLevel reads GZIP_LEVEL, falling back to the default level when it is not a valid flate level
+func parseGzipLevel() int {
+	provided := os.Getenv("GZIP_LEVEL")
+	if provided == "" {
+		return gzip.DefaultCompression
+	}
+
+	level, err := strconv.Atoi(provided)
+	if err != nil || level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		logging.WithField("gzip-level", provided).Warn("Invalid gzip level, falling back to default compression")
+
+		return gzip.DefaultCompression
+	}
+
+	return level
+}
@@ -0,0 +1,381 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+)
+
+type routeHandler func(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry)
+
+const (
+	emptyBody            = "empty"
+	computeResponseShape = "201 (200 when nothing was computed, 200 or 207 when only some tuples were computed, " +
+		"400 with {code: no_tuples} on an empty batch unless allow_empty, which answers 200 with {processed: 0}) " +
+		"with json {dry_run, tuples, skipped_unchanged, skipped_not_newer, deduplicated, timing, computed, no_op, " +
+		"succeeded, failed, failures, regions}, where no_op is whether no tuple was computed, " +
+		"failures are {region_name, realm_slug, target_timestamp, reason, retryable} " +
+		"and regions maps region to realm to a list of {target_timestamp, outcome} with group_by=region; " +
+		"with Accept: application/x-ndjson, 200 with a {region_name, realm_slug, target_timestamp, outcome, reason, " +
+		"retryable} line per tuple as it completes, ending with the json response or {error}"
+	cleanupResponseShape   = "200 with json {realms, deleted, failed, no_op}, where no_op is whether nothing was deleted"
+	adminLastResponseShape = "json {method, query, headers, request_body, status, response_body, ...}, where headers " +
+		"are redacted and bodies are captured raw, 403 without X-Admin-Token"
+	bulkCleanupResponseShape = "200 with json {dry_run, targets: [{target, realms, deleted, failed, no_op, note}]}, " +
+		"where realms is the count that would be cleaned up on a dry run and note explains a target with nothing " +
+		"to clean up; 500 with the targets already cleaned up and {error} when a target fails"
+	resumableCleanupResponseShape = "200 with json {resume, cursor, realms, deleted, failed, no_op}, where the cursor " +
+		"is passed back to resume and the counts cover this invocation alone"
+	downloadResponseShape = "201 with empty body, or json {skipped, downloaded, failed} when checkpointed, " +
+		"200 or 207 when some realms failed to download, 422 when a region is unknown"
+	exportResponseShape = "200 with newline-delimited json items, gzip-encoded when accepted, " +
+		"ending with {truncated, cursor} when the read deadline is reached or {error, cursor} when the export fails"
+	itemPurgeResponseShape = "json {items, index_retained}, where items maps item-id to purged or not-found; " +
+		"only stored item objects are deleted, the items database entries read by the sync filter are retained"
+	selftestResponseShape = "json {region_name, realm_slug, passed, phases}, 500 when a phase failed, " +
+		"403 when the realm is not listed in SELFTEST_CANARIES, 503 when none are listed"
+	tuplesBody = "json array of region-realm-timestamp tuples with an optional priority, " +
+		"or {\"tuples\": [...], \"options\": {...}}"
+)
+
+// route describes a gateway route, doubling as its machine-readable contract
+type route struct {
+	Method        string   `json:"method"`
+	Path          string   `json:"path"`
+	Query         []string `json:"query,omitempty"`
+	Body          string   `json:"body"`
+	Response      string   `json:"response"`
+	Mutating      bool     `json:"mutating"`
+	RequiresState bool     `json:"-"`
+
+	// Unqueued routes are answered without waiting for a slot, so that probes and introspection work under load
+	Unqueued bool `json:"-"`
+
+	// Admin routes require X-Admin-Token, so that their responses are never kept by shared caches
+	Admin bool `json:"-"`
+
+	// Dependencies are the downstreams the route calls, so that it is only blocked when one of them is down
+	Dependencies []dependencyName `json:"dependencies,omitempty"`
+
+	handler routeHandler
+}
+
+// computeQuery lists the compute options that may be provided as query params
+var computeQuery = []string{"dry_run", "skip_unchanged", "dedupe", "timing", "lenient", "use_latest", "allow_empty"}
+
+// computeRouteQuery adds the query params that only shape the response of the compute routes
+var computeRouteQuery = append(append([]string{}, computeQuery...), "group_by")
+
+var routes []route
+
+func init() {
+	routes = []route{
+		{
+			Method:   "GET",
+			Path:     "/",
+			Body:     emptyBody,
+			Response: "json {service, version, routes}",
+			handler:  handleRoot,
+		},
+		{
+			Method:        "POST",
+			Path:          "/download-all-auctions",
+			Query:         []string{"region", "force"},
+			Body:          emptyBody,
+			Response:      downloadResponseShape,
+			Mutating:      true,
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency, hellDependency, busDependency},
+			handler:       handleDownloadAllAuctions,
+		},
+		{
+			Method:        "POST",
+			Path:          "/cleanup-all-manifests",
+			Body:          emptyBody,
+			Response:      cleanupResponseShape,
+			Mutating:      true,
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency, hellDependency, busDependency},
+			handler:       handleCleanupAllManifests,
+		},
+		{
+			Method:        "POST",
+			Path:          "/cleanup-all-auctions",
+			Body:          emptyBody,
+			Response:      cleanupResponseShape,
+			Mutating:      true,
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency, hellDependency, busDependency},
+			handler:       handleCleanupAllAuctions,
+		},
+		{
+			Method:        "POST",
+			Path:          "/compute-all-live-auctions",
+			Query:         computeRouteQuery,
+			Body:          tuplesBody,
+			Response:      computeResponseShape,
+			Mutating:      true,
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency, hellDependency, busDependency},
+			handler:       handleComputeAllLiveAuctions,
+		},
+		{
+			Method:        "POST",
+			Path:          "/compute-all-pricelist-histories",
+			Query:         computeRouteQuery,
+			Body:          tuplesBody,
+			Response:      computeResponseShape,
+			Mutating:      true,
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency, hellDependency, busDependency},
+			handler:       handleComputeAllPricelistHistories,
+		},
+		{
+			Method:        "POST",
+			Path:          "/sync-all-items",
+			Body:          "base64-encoded gzipped json array of item-ids, or one item-id per line as application/x-ndjson",
+			Response:      "201 with json {blocked}, 202 with the prior result for a repeat within the dedup window",
+			Mutating:      true,
+			RequiresState: true,
+			Dependencies:  []dependencyName{busDependency},
+			handler:       handleSyncAllItems,
+		},
+		{
+			Method:        "POST",
+			Path:          "/items/purge",
+			Body:          "base64-encoded gzipped json array of item-ids",
+			Response:      itemPurgeResponseShape,
+			Mutating:      true,
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency},
+			handler:       handleItemsPurge,
+		},
+		{
+			Method:        "POST",
+			Path:          "/cleanup-all-pricelist-histories",
+			Query:         []string{"cursor"},
+			Body:          emptyBody,
+			Response:      resumableCleanupResponseShape,
+			Mutating:      true,
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency, hellDependency, busDependency},
+			handler:       handleCleanupAllPricelistHistories,
+		},
+		{
+			Method:        "POST",
+			Path:          "/cleanup",
+			Query:         []string{"dry_run"},
+			Body:          "json {targets}, where targets are any of pricelist_histories, auctions, live_auctions and manifests",
+			Response:      bulkCleanupResponseShape,
+			Mutating:      true,
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency, hellDependency, busDependency},
+			handler:       handleBulkCleanup,
+		},
+		{
+			Method:        "POST",
+			Path:          "/replay-live-auctions",
+			Body:          "json region-realm-timestamp tuple identifying stored auctions",
+			Response:      "201 with empty body, 404 when the auctions are no longer stored",
+			Mutating:      true,
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency, busDependency},
+			handler:       handleReplayLiveAuctions,
+		},
+		{
+			Method:        "POST",
+			Path:          "/selftest",
+			Body:          "empty, or json {region_name, realm_slug} naming one of the configured canary realms",
+			Response:      selftestResponseShape,
+			Mutating:      true,
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency, hellDependency, busDependency},
+			handler:       handleSelftest,
+		},
+		{
+			Method:        "POST",
+			Path:          "/compute-status",
+			Body:          tuplesBody,
+			Response:      "json array of tuples with {live_auctions_received, pricelist_histories_received, outcomes}",
+			RequiresState: true,
+			Dependencies:  []dependencyName{hellDependency},
+			handler:       handleComputeStatus,
+		},
+		{
+			Method:   "POST",
+			Path:     "/decode-tuples",
+			Query:    computeQuery,
+			Body:     "legacy json array of region-realm-timestamp tuples",
+			Response: "json {tuples, options}, the canonical compute request body",
+			handler:  handleDecodeTuples,
+		},
+		{
+			Method:   "GET",
+			Path:     "/healthz",
+			Body:     emptyBody,
+			Response: "200 with empty body, 503 until auto-warmup has completed, which is retried until it does",
+			Unqueued: true,
+			handler:  handleHealthz,
+		},
+		{
+			Method:   "GET",
+			Path:     "/routes",
+			Body:     emptyBody,
+			Response: "json array of {method, path, query, body, response, mutating}",
+			handler:  handleRoutes,
+		},
+		{
+			Method:        "GET",
+			Path:          "/item",
+			Query:         []string{"id"},
+			Body:          emptyBody,
+			Response:      "json item, or 404 when the item has not been synced",
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency},
+			handler:       handleItem,
+		},
+		{
+			Method:        "GET",
+			Path:          "/items/count",
+			Query:         []string{"quality", "item_class", "cursor"},
+			Body:          emptyBody,
+			Response:      "json {count, truncated, cursor}, where a truncated count is resumed by passing its cursor back",
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency},
+			handler:       handleItemsCount,
+		},
+		{
+			Method:        "GET",
+			Path:          "/items/export",
+			Query:         []string{"cursor"},
+			Body:          emptyBody,
+			Response:      exportResponseShape,
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency},
+			handler:       handleItemsExport,
+		},
+		{
+			Method:        "GET",
+			Path:          "/ping",
+			Query:         []string{"route"},
+			Body:          emptyBody,
+			Response:      "json {reachable, dependencies: [{name, reachable, latency_ms, error}]}, 503 when unreachable",
+			RequiresState: true,
+			handler:       handlePing,
+		},
+		{
+			Method:        "GET",
+			Path:          "/audit",
+			Query:         []string{"route", "since", "until", "limit"},
+			Body:          emptyBody,
+			Response:      "json array of {identity, route, scope, scope_size, timestamp, status}",
+			RequiresState: true,
+			Dependencies:  []dependencyName{hellDependency},
+			handler:       handleAudit,
+		},
+		{
+			Method:   "GET",
+			Path:     "/errors",
+			Body:     emptyBody,
+			Response: "json array of {route, request_id, status, message, timestamp}, newest first, 403 without X-Admin-Token",
+			Admin:    true,
+			handler:  handleErrors,
+		},
+		{
+			Method:   "GET",
+			Path:     "/admin/limits",
+			Body:     emptyBody,
+			Response: "json {breakers, operations_in_flight}, 403 without X-Admin-Token",
+			Unqueued: true,
+			Admin:    true,
+			handler:  handleAdminLimits,
+		},
+		{
+			Method:   "GET",
+			Path:     "/admin/queue",
+			Body:     emptyBody,
+			Response: "json {active, depth, peak_depth, waited, average_wait_ms}, 403 without X-Admin-Token",
+			Unqueued: true,
+			Admin:    true,
+			handler:  handleAdminQueue,
+		},
+		{
+			Method:   "GET",
+			Path:     "/admin/last",
+			Query:    []string{"route"},
+			Body:     emptyBody,
+			Response: adminLastResponseShape,
+			Admin:    true,
+			handler:  handleAdminLast,
+		},
+		{
+			Method:   "GET",
+			Path:     "/diagnostics",
+			Body:     emptyBody,
+			Response: "json {ready, version, config, limits, queue, errors}, 403 without X-Admin-Token",
+			Unqueued: true,
+			Admin:    true,
+			handler:  handleDiagnostics,
+		},
+		{
+			Method:        "POST",
+			Path:          "/integrity-sample",
+			Query:         []string{"category", "size"},
+			Body:          emptyBody,
+			Response:      "200 with json {category, sampled, passed, failures: [{path, error}]}",
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency},
+			handler:       handleIntegritySample,
+		},
+		{
+			Method:        "GET",
+			Path:          "/freshness",
+			Body:          emptyBody,
+			Response:      "json {threshold_seconds, total_stale, realms: [...]}",
+			RequiresState: true,
+			Dependencies:  []dependencyName{storageDependency, hellDependency},
+			handler:       handleFreshness,
+		},
+	}
+
+	// refusing max-body overrides naming no route, as the routes are only known once declared here
+	if unknown := unknownMaxBodyOverrides(routeMaxBodyBytes, routes); len(unknown) > 0 {
+		logging.WithField("overrides", unknown).Fatal("Max-body overrides name no route")
+
+		return
+	}
+}
+
+func findRoute(path string) (route, bool) {
+	for _, rt := range routes {
+		if rt.Path == path {
+			return rt, true
+		}
+	}
+
+	return route{}, false
+}
+
+func handleRoutes(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	writeJSONResponse(w, http.StatusOK, routes)
+}
+
+type rootResponse struct {
+	Service string `json:"service"`
+	Version string `json:"version"`
+	Routes  string `json:"routes"`
+}
+
+func currentIdentity() rootResponse {
+	return rootResponse{
+		Service: serviceName,
+		Version: serviceVersion,
+		Routes:  "/routes",
+	}
+}
+
+// handleRoot identifies the service to anyone hitting the base url, pointing them at the route listing
+func handleRoot(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	writeJSONResponse(w, http.StatusOK, currentIdentity())
+}
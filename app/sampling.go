@@ -0,0 +1,39 @@
+package app
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"strconv"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+)
+
+var logSampleRate = 1.0
+
+func parseLogSampleRate() (float64, error) {
+	provided := os.Getenv("LOG_SAMPLE_RATE")
+	if provided == "" {
+		return 1.0, nil
+	}
+
+	rate, err := strconv.ParseFloat(provided, 64)
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 || rate > 1 {
+		return 0, errors.New("log sample rate must be between 0.0 and 1.0")
+	}
+
+	return rate, nil
+}
+
+// infoSampled logs high-volume info lines at the configured sample rate
+// warn and error lines must go through the logging package directly, as they are never sampled
+func infoSampled(args ...interface{}) {
+	if logSampleRate < 1 && rand.Float64() >= logSampleRate {
+		return
+	}
+
+	logging.Info(args...)
+}
@@ -0,0 +1,278 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+)
+
+type selftestPhase struct {
+	Name       string `json:"name"`
+	Succeeded  bool   `json:"succeeded"`
+	Skipped    bool   `json:"skipped"`
+	DurationMs int64  `json:"duration_ms"`
+	Note       string `json:"note,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type selftestResponse struct {
+	sotah.RegionRealmTuple
+	Passed bool            `json:"passed"`
+	Phases []selftestPhase `json:"phases"`
+}
+
+// selftestCanaries are the only realms the selftest may be run against, where the first is run when none is named
+var selftestCanaries []sotah.RegionRealmTuple
+
+func parseSelftestCanaries() ([]sotah.RegionRealmTuple, error) {
+	out := []sotah.RegionRealmTuple{}
+	for _, provided := range strings.Split(os.Getenv("SELFTEST_CANARIES"), ",") {
+		provided = strings.TrimSpace(provided)
+		if provided == "" {
+			continue
+		}
+
+		parts := strings.Split(provided, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return []sotah.RegionRealmTuple{}, fmt.Errorf("canary %s is not of the form region/realm", provided)
+		}
+
+		out = append(out, sotah.RegionRealmTuple{RegionName: parts[0], RealmSlug: parts[1]})
+	}
+
+	return out, nil
+}
+
+func canaryNames() []string {
+	out := []string{}
+	for _, canary := range selftestCanaries {
+		out = append(out, canary.RegionName+"/"+canary.RealmSlug)
+	}
+
+	return out
+}
+
+var (
+	errNoCanaries = errors.New("no canary realms are configured")
+	errNotCanary  = errors.New("realm is not a configured canary")
+)
+
+// resolveCanary reads the canary region-realm from the request body, falling back to the first configured canary
+// when the body is empty, and refusing any realm that is not a configured canary
+func resolveCanary(body io.Reader) (sotah.RegionRealmTuple, error) {
+	if len(selftestCanaries) == 0 {
+		return sotah.RegionRealmTuple{}, errNoCanaries
+	}
+
+	canary := selftestCanaries[0]
+	if err := json.NewDecoder(body).Decode(&canary); err != nil && err != io.EOF {
+		return sotah.RegionRealmTuple{}, err
+	}
+
+	for _, configured := range selftestCanaries {
+		if configured == canary {
+			return canary, nil
+		}
+	}
+
+	return sotah.RegionRealmTuple{}, errNotCanary
+}
+
+// canaryRegionRealms narrows the realm catalog down to the canary realm, so that no other realm is touched
+func canaryRegionRealms(sta fn.GatewayState, canary sotah.RegionRealmTuple) (sotah.RegionRealms, bool, error) {
+	regionRealms, err := getAllRegionRealms(sta)
+	if err != nil {
+		return sotah.RegionRealms{}, false, err
+	}
+
+	for _, realm := range regionRealms[blizzard.RegionName(canary.RegionName)] {
+		if realm.Slug == blizzard.RealmSlug(canary.RealmSlug) {
+			return sotah.RegionRealms{blizzard.RegionName(canary.RegionName): sotah.Realms{realm}}, true, nil
+		}
+	}
+
+	return sotah.RegionRealms{}, false, nil
+}
+
+// downloadCanary downloads the canary realm, falling back to its latest stored tuple when there were no new
+// auctions to download
+func downloadCanary(
+	sta fn.GatewayState,
+	canary sotah.RegionRealms,
+) (sotah.RegionRealmTimestampTuples, string, error) {
+	downloads, err := downloadAuctions(sta, canary)
+	if err != nil {
+		return sotah.RegionRealmTimestampTuples{}, "", err
+	}
+	if len(downloads.failed) > 0 {
+		return sotah.RegionRealmTimestampTuples{}, "", errors.New("auctions could not be downloaded")
+	}
+	if len(downloads.tuples) > 0 {
+		return downloads.tuples, "", nil
+	}
+
+	latest := sotah.RegionRealmTimestampTuples{}
+	for _, tuple := range downloads.unchanged {
+		latest = append(latest, sotah.RegionRealmTimestampTuple{RegionRealmTuple: tuple})
+	}
+	resolved, unresolved, err := resolveLatestTuples(sta, latest)
+	if err != nil {
+		return sotah.RegionRealmTimestampTuples{}, "", err
+	}
+	if len(resolved) == 0 || len(unresolved) > 0 {
+		return sotah.RegionRealmTimestampTuples{}, "", errors.New("no auctions were downloaded or previously stored")
+	}
+
+	return resolved, "no new auctions were available, the latest stored auctions were used", nil
+}
+
+// computeCanary computes live auctions for the canary tuples through the act service alone, so that nothing is
+// published to the receivers of computed live auctions or item syncs
+func computeCanary(sta fn.GatewayState, tuples sotah.RegionRealmTimestampTuples) error {
+	jobs, err := callActCompute(sta, "/compute-live-auctions", liveAuctionsComputeWorkers, tuples)
+	if err != nil {
+		return err
+	}
+
+	computed := 0
+	for job := range jobs {
+		body, ok := acceptedActComputeBody(job, nil)
+		if !ok {
+			continue
+		}
+		if _, err := sotah.NewRegionRealmSummaryTuple(string(body)); err != nil {
+			continue
+		}
+
+		computed++
+	}
+	if computed < len(tuples) {
+		return errors.New("not every downloaded tuple was computed")
+	}
+
+	return nil
+}
+
+// runSelftest downloads, computes live auctions for, and cleans up manifests of the canary realm alone
+// each phase is only run when the phases before it succeeded
+func runSelftest(sta fn.GatewayState, canary sotah.RegionRealms) []selftestPhase {
+	var tuples sotah.RegionRealmTimestampTuples
+	phases := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{"download", func() (string, error) {
+			var (
+				note string
+				err  error
+			)
+			tuples, note, err = downloadCanary(sta, canary)
+
+			return note, err
+		}},
+		{"compute-live-auctions", func() (string, error) {
+			return "", computeCanary(sta, tuples)
+		}},
+		{"cleanup-manifests", func() (string, error) {
+			return "", sta.CleanupRegionRealmsManifests(canary)
+		}},
+	}
+
+	out := []selftestPhase{}
+	failed := false
+	for _, phase := range phases {
+		if failed {
+			out = append(out, selftestPhase{Name: phase.name, Skipped: true})
+
+			continue
+		}
+
+		startTime := time.Now()
+		note, err := phase.run()
+		result := selftestPhase{
+			Name:       phase.name,
+			Succeeded:  err == nil,
+			DurationMs: int64(time.Since(startTime) / time.Millisecond),
+			Note:       note,
+		}
+		if err != nil {
+			result.Error = err.Error()
+			failed = true
+		}
+
+		out = append(out, result)
+	}
+
+	return out
+}
+
+func handleSelftest(w http.ResponseWriter, r *http.Request, sta fn.GatewayState, audit *auditEntry) {
+	canary, err := resolveCanary(r.Body)
+	if err != nil {
+		switch err {
+		case errNoCanaries:
+			writeErroneousResponse(w, http.StatusServiceUnavailable, err.Error())
+
+			return
+		case errNotCanary:
+			writeErroneousResponse(w, http.StatusForbidden, err.Error())
+
+			return
+		}
+
+		if !writeBodyReadError(w, err) {
+			writeErroneousResponse(w, http.StatusBadRequest, err.Error())
+		}
+
+		return
+	}
+
+	regionRealms, exists, err := canaryRegionRealms(sta, canary)
+	if err != nil {
+		writeErroneousResponse(w, http.StatusInternalServerError, "Could not gather realms")
+
+		logging.WithField("error", err.Error()).Error("Could not gather realms")
+
+		return
+	}
+	if !exists {
+		writeErroneousResponse(w, http.StatusNotFound, "Canary realm not found")
+
+		return
+	}
+
+	audit.Scope = []string{canary.RegionName + "/" + canary.RealmSlug}
+	audit.ScopeSize = 1
+
+	resp := selftestResponse{RegionRealmTuple: canary, Passed: true, Phases: runSelftest(sta, regionRealms)}
+	for _, phase := range resp.Phases {
+		if !phase.Succeeded {
+			resp.Passed = false
+		}
+	}
+
+	if !resp.Passed {
+		logging.WithFields(logrus.Fields{
+			"region": canary.RegionName,
+			"realm":  canary.RealmSlug,
+			"phases": resp.Phases,
+		}).Error("Selftest failed")
+
+		writeJSONResponse(w, http.StatusInternalServerError, resp)
+
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, resp)
+}
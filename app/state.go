@@ -0,0 +1,93 @@
+package app
+
+import (
+	"errors"
+	"sync"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/hell"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+)
+
+var state fn.GatewayState
+var stateReady bool
+var stateMutex sync.Mutex
+
+// resolveState lazily produces the gateway state on first use
+// unlike a sync.Once, a failed attempt is retried by the next caller rather than wedging the instance
+// fn.NewGatewayState calls log.Fatalf on every failure it encounters, so only failures caught by
+// preflightState are retried or surfaced as a 503; any other failure still exits the instance
+func resolveState() (fn.GatewayState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	if stateReady {
+		return state, nil
+	}
+
+	// retrying the project-id lookup where the metadata server could not be reached on init
+	if projectId == "" {
+		if resolved, err := metadata.Get("project/project-id"); err == nil {
+			projectId = resolved
+		}
+	}
+
+	config := fn.GatewayStateConfig{ProjectId: projectId}
+
+	logging.WithFields(logrus.Fields{
+		"project":      config.ProjectId,
+		"service-name": serviceName,
+	}).Info("Producing fn-gateway state")
+
+	if err := preflightState(config); err != nil {
+		logging.WithFields(logrus.Fields{
+			"error":        err.Error(),
+			"project":      config.ProjectId,
+			"service-name": serviceName,
+		}).Error("Failed fn-gateway state preflight")
+
+		return fn.GatewayState{}, err
+	}
+
+	sta, err := fn.NewGatewayState(config)
+	if err != nil {
+		logging.WithFields(logrus.Fields{
+			"error":        err.Error(),
+			"project":      config.ProjectId,
+			"service-name": serviceName,
+		}).Error("Failed to generate fn-gateway state")
+
+		return fn.GatewayState{}, err
+	}
+
+	state = sta
+	stateReady = true
+
+	return state, nil
+}
+
+// preflightState checks the config and the first dependency fn.NewGatewayState reaches for, returning an error
+// where the dependency would otherwise exit the instance
+func preflightState(config fn.GatewayStateConfig) error {
+	if config.ProjectId == "" {
+		return errors.New("project-id was blank")
+	}
+
+	hellClient, err := hell.NewClient(config.ProjectId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := hellClient.Close(); err != nil {
+			logging.WithField("error", err.Error()).Error("Failed to close preflight hell client")
+		}
+	}()
+
+	if _, err := hellClient.GetActEndpoints(); err != nil {
+		return err
+	}
+
+	return nil
+}
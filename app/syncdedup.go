@@ -0,0 +1,96 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/blizzard"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/state/fn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const syncDedupCollection = "gateway_sync_dedup"
+
+// syncDedupTTL is how long a synced item-id set is answered from its prior result, where zero disables dedup
+var syncDedupTTL time.Duration
+
+func parseSyncDedupTTL() (time.Duration, error) {
+	provided := os.Getenv("SYNC_DEDUP_TTL_SECONDS")
+	if provided == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 0 {
+		return 0, errors.New("sync dedup ttl seconds must not be negative")
+	}
+
+	return time.Duration(parsed) * time.Second, nil
+}
+
+// syncDedupRecord is the result of a sync, keyed by the hash of its item-id set
+type syncDedupRecord struct {
+	Blocked  blizzard.ItemIds `firestore:"blocked"`
+	SyncedAt int64            `firestore:"synced_at"`
+}
+
+// syncDedupKey hashes the sorted item-ids, so that the same set in any order shares a key
+func syncDedupKey(ids blizzard.ItemIds) string {
+	sorted := make([]int, len(ids))
+	for i, id := range ids {
+		sorted[i] = int(id)
+	}
+	sort.Ints(sorted)
+
+	hash := sha256.New()
+	for _, id := range sorted {
+		fmt.Fprintf(hash, "%d,", id)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func syncDedupPath(key string) string {
+	return fmt.Sprintf("%s/%s", syncDedupCollection, key)
+}
+
+// getRecentSync fetches the result of the same item-id set when it was synced within the ttl
+func getRecentSync(sta fn.GatewayState, key string) (syncItemsResponse, bool, error) {
+	docsnap, err := sta.IO.HellClient.Doc(syncDedupPath(key)).Get(sta.IO.HellClient.Context)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return syncItemsResponse{}, false, nil
+		}
+
+		return syncItemsResponse{}, false, err
+	}
+
+	var record syncDedupRecord
+	if err := docsnap.DataTo(&record); err != nil {
+		return syncItemsResponse{}, false, err
+	}
+	if time.Since(time.Unix(record.SyncedAt, 0)) > syncDedupTTL {
+		return syncItemsResponse{}, false, nil
+	}
+
+	return syncItemsResponse{Blocked: record.Blocked}, true, nil
+}
+
+func recordSync(sta fn.GatewayState, key string, resp syncItemsResponse) error {
+	_, err := sta.IO.HellClient.Doc(syncDedupPath(key)).Set(sta.IO.HellClient.Context, syncDedupRecord{
+		Blocked:  resp.Blocked,
+		SyncedAt: time.Now().Unix(),
+	})
+
+	return err
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// allowedTenants are the tenants that requests may attribute themselves to, for observability alone
+var allowedTenants = map[string]struct{}{}
+
+// requireTenant is whether requests without a recognized tenant are refused
+var requireTenant bool
+
+func parseAllowedTenants() map[string]struct{} {
+	out := map[string]struct{}{}
+	for _, tenant := range strings.Split(os.Getenv("TENANTS"), ",") {
+		tenant = strings.TrimSpace(tenant)
+		if tenant == "" {
+			continue
+		}
+
+		out[tenant] = struct{}{}
+	}
+
+	return out
+}
+
+// resolveTenant reads the X-Tenant header, where unrecognized tenants resolve to empty unless a tenant is required
+func resolveTenant(r *http.Request) (string, error) {
+	tenant := r.Header.Get("X-Tenant")
+	if _, ok := allowedTenants[tenant]; ok {
+		return tenant, nil
+	}
+
+	if requireTenant {
+		return "", errors.New("a recognized X-Tenant header is required")
+	}
+
+	return "", nil
+}
@@ -0,0 +1,25 @@
+package app
+
+import "time"
+
+type phaseTiming struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// phaseTimer records how long each phase of a request took, each phase starting where the last one ended
+// phases run by downstream services (per-tuple computation, storage writes) are only seen as a whole
+type phaseTimer struct {
+	last   time.Time
+	phases []phaseTiming
+}
+
+func newPhaseTimer() *phaseTimer {
+	return &phaseTimer{last: time.Now(), phases: []phaseTiming{}}
+}
+
+func (t *phaseTimer) mark(name string) {
+	now := time.Now()
+	t.phases = append(t.phases, phaseTiming{Name: name, DurationMs: int64(now.Sub(t.last) / time.Millisecond)})
+	t.last = now
+}
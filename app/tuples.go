@@ -0,0 +1,311 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+)
+
+// defaultRegion is applied to tuples that omit their region
+var defaultRegion string
+
+// timestampSkew is how far past now a tuple's target timestamp may be before it is considered in the future
+var timestampSkew = 5 * time.Minute
+
+func parseTimestampSkew() (time.Duration, error) {
+	provided := os.Getenv("TIMESTAMP_SKEW_SECONDS")
+	if provided == "" {
+		return 5 * time.Minute, nil
+	}
+
+	parsed, err := strconv.Atoi(provided)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 0 {
+		return 0, errors.New("timestamp skew seconds must not be negative")
+	}
+
+	return time.Duration(parsed) * time.Second, nil
+}
+
+type computeOptions struct {
+	DryRun        bool `json:"dry_run"`
+	SkipUnchanged bool `json:"skip_unchanged"`
+	Dedupe        bool `json:"dedupe"`
+	Timing        bool `json:"timing"`
+	Lenient       bool `json:"lenient"`
+	UseLatest     bool `json:"use_latest"`
+	AllowEmpty    bool `json:"allow_empty"`
+}
+
+func newComputeOptions(query url.Values) computeOptions {
+	return computeOptions{
+		DryRun:        query.Get("dry_run") == "true",
+		SkipUnchanged: query.Get("skip_unchanged") == "true",
+		Dedupe:        query.Get("dedupe") == "true",
+		Timing:        query.Get("timing") == "true",
+		Lenient:       query.Get("lenient") == "true",
+		UseLatest:     query.Get("use_latest") == "true",
+		AllowEmpty:    query.Get("allow_empty") == "true",
+	}
+}
+
+// merge enables any option that is enabled on either side
+func (opts computeOptions) merge(other computeOptions) computeOptions {
+	return computeOptions{
+		DryRun:        opts.DryRun || other.DryRun,
+		SkipUnchanged: opts.SkipUnchanged || other.SkipUnchanged,
+		Dedupe:        opts.Dedupe || other.Dedupe,
+		Timing:        opts.Timing || other.Timing,
+		Lenient:       opts.Lenient || other.Lenient,
+		UseLatest:     opts.UseLatest || other.UseLatest,
+		AllowEmpty:    opts.AllowEmpty || other.AllowEmpty,
+	}
+}
+
+type computeRequest struct {
+	Tuples  sotah.RegionRealmTimestampTuples
+	Options computeOptions
+}
+
+// canonicalComputeRequest is the envelope form of a compute request body
+type canonicalComputeRequest struct {
+	Tuples  sotah.RegionRealmTimestampTuples `json:"tuples"`
+	Options computeOptions                   `json:"options"`
+}
+
+// decodeComputeRequest accepts either a bare json array of tuples or an envelope of the form
+// {"tuples": [...], "options": {...}}, where body options are merged with query options
+func decodeComputeRequest(body io.Reader, query url.Values) (computeRequest, error) {
+	decoder := json.NewDecoder(body)
+	out := computeRequest{
+		Tuples:  sotah.RegionRealmTimestampTuples{},
+		Options: newComputeOptions(query),
+	}
+
+	token, err := decoder.Token()
+	if err != nil {
+		return computeRequest{}, err
+	}
+	if token == nil {
+		return out, nil
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return computeRequest{}, errors.New("tuples must be a json array or envelope object")
+	}
+
+	switch delim {
+	case '[':
+		out.Tuples, err = decodeTuples(decoder)
+		if err != nil {
+			return computeRequest{}, err
+		}
+
+		return out, nil
+	case '{':
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return computeRequest{}, err
+			}
+
+			switch key {
+			case "tuples":
+				if _, err := decoder.Token(); err != nil {
+					return computeRequest{}, err
+				}
+
+				out.Tuples, err = decodeTuples(decoder)
+				if err != nil {
+					return computeRequest{}, err
+				}
+			case "options":
+				var bodyOptions computeOptions
+				if err := decoder.Decode(&bodyOptions); err != nil {
+					return computeRequest{}, err
+				}
+
+				out.Options = out.Options.merge(bodyOptions)
+			default:
+				return computeRequest{}, errors.New("unexpected envelope field")
+			}
+		}
+
+		// consuming the closing of the envelope
+		if _, err := decoder.Token(); err != nil {
+			return computeRequest{}, err
+		}
+
+		return out, nil
+	default:
+		return computeRequest{}, errors.New("tuples must be a json array or envelope object")
+	}
+}
+
+// tupleDecodeError locates a failure to decode a tuple within the request body
+type tupleDecodeError struct {
+	Reason string `json:"error"`
+	Index  int    `json:"index"`
+	Offset int64  `json:"offset,omitempty"`
+	Field  string `json:"field,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+func newTupleDecodeError(index int, err error) tupleDecodeError {
+	out := tupleDecodeError{Reason: err.Error(), Index: index}
+	switch typedErr := err.(type) {
+	case *json.SyntaxError:
+		out.Offset = typedErr.Offset
+	case *json.UnmarshalTypeError:
+		out.Reason = fmt.Sprintf("expected %s but got %s", typedErr.Type, typedErr.Value)
+		out.Offset = typedErr.Offset
+		out.Field = typedErr.Field
+		out.Value = typedErr.Value
+	}
+
+	return out
+}
+
+func (e tupleDecodeError) Error() string {
+	return fmt.Sprintf("tuple %d: %s", e.Index, e.Reason)
+}
+
+// normalizeTuple lowercases and trims the region and realm slug to match the catalog's canonical casing,
+// applying the default region when the region is omitted
+func normalizeTuple(tuple sotah.RegionRealmTimestampTuple) sotah.RegionRealmTimestampTuple {
+	tuple.RegionName = strings.ToLower(strings.TrimSpace(tuple.RegionName))
+	tuple.RealmSlug = strings.ToLower(strings.TrimSpace(tuple.RealmSlug))
+	if tuple.RegionName == "" {
+		tuple.RegionName = defaultRegion
+	}
+
+	return tuple
+}
+
+// prioritizedTuple is a region-realm-timestamp tuple with its optional priority within the batch
+type prioritizedTuple struct {
+	sotah.RegionRealmTimestampTuple
+	Priority int `json:"priority"`
+}
+
+// decodeTuples streams the remainder of an opened json array of region-realm-timestamp tuples
+// element-by-element rather than buffering the entire request body ahead of decoding
+// tuples are handed back highest priority first, with ties left in their input order
+func decodeTuples(decoder *json.Decoder) (sotah.RegionRealmTimestampTuples, error) {
+	prioritized := []prioritizedTuple{}
+	for decoder.More() {
+		var tuple prioritizedTuple
+		if err := decoder.Decode(&tuple); err != nil {
+			// leaving body read failures unwrapped, as they are not the fault of the tuple
+			if err == errBodyReadTimeout || err == errBodyTooLarge {
+				return sotah.RegionRealmTimestampTuples{}, err
+			}
+
+			return sotah.RegionRealmTimestampTuples{}, newTupleDecodeError(len(prioritized), err)
+		}
+
+		tuple.RegionRealmTimestampTuple = normalizeTuple(tuple.RegionRealmTimestampTuple)
+
+		prioritized = append(prioritized, tuple)
+	}
+
+	// consuming the closing of the array
+	if _, err := decoder.Token(); err != nil {
+		return sotah.RegionRealmTimestampTuples{}, err
+	}
+
+	sort.SliceStable(prioritized, func(i, j int) bool {
+		return prioritized[i].Priority > prioritized[j].Priority
+	})
+
+	out := make(sotah.RegionRealmTimestampTuples, len(prioritized))
+	for i, tuple := range prioritized {
+		out[i] = tuple.RegionRealmTimestampTuple
+	}
+
+	return out, nil
+}
+
+// splitDuplicateTuples separates the first occurrence of each tuple from its exact repeats
+func splitDuplicateTuples(
+	tuples sotah.RegionRealmTimestampTuples,
+) (sotah.RegionRealmTimestampTuples, sotah.RegionRealmTimestampTuples) {
+	seen := map[sotah.RegionRealmTimestampTuple]struct{}{}
+	unique := sotah.RegionRealmTimestampTuples{}
+	duplicates := sotah.RegionRealmTimestampTuples{}
+	for _, tuple := range tuples {
+		if _, ok := seen[tuple]; ok {
+			duplicates = append(duplicates, tuple)
+
+			continue
+		}
+
+		seen[tuple] = struct{}{}
+		unique = append(unique, tuple)
+	}
+
+	return unique, duplicates
+}
+
+// futureTuples gathers the tuples whose target timestamps are beyond now plus the allowed skew
+func futureTuples(tuples sotah.RegionRealmTimestampTuples, now time.Time) sotah.RegionRealmTimestampTuples {
+	limit := now.Add(timestampSkew).Unix()
+	out := sotah.RegionRealmTimestampTuples{}
+	for _, tuple := range tuples {
+		if int64(tuple.TargetTimestamp) > limit {
+			out = append(out, tuple)
+		}
+	}
+
+	return out
+}
+
+const ifNewerThanHeader = "If-Newer-Than"
+
+// parseIfNewerThan reads the unix timestamp of the If-Newer-Than header, flagging whether one was provided
+func parseIfNewerThan(header http.Header) (int64, bool, error) {
+	provided := header.Get(ifNewerThanHeader)
+	if provided == "" {
+		return 0, false, nil
+	}
+
+	parsed, err := strconv.ParseInt(provided, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return parsed, true, nil
+}
+
+// splitNotNewerTuples separates the tuples whose target timestamp is past since from those that are not
+func splitNotNewerTuples(
+	tuples sotah.RegionRealmTimestampTuples,
+	since int64,
+) (sotah.RegionRealmTimestampTuples, sotah.RegionRealmTimestampTuples) {
+	newer := sotah.RegionRealmTimestampTuples{}
+	notNewer := sotah.RegionRealmTimestampTuples{}
+	for _, tuple := range tuples {
+		if int64(tuple.TargetTimestamp) > since {
+			newer = append(newer, tuple)
+
+			continue
+		}
+
+		notNewer = append(notNewer, tuple)
+	}
+
+	return newer, notNewer
+}
@@ -0,0 +1,191 @@
+package app
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/sotah"
+)
+
+func newTestTuple(regionName string, realmSlug string, targetTimestamp int) sotah.RegionRealmTimestampTuple {
+	return sotah.RegionRealmTimestampTuple{
+		RegionRealmTuple: sotah.RegionRealmTuple{RegionName: regionName, RealmSlug: realmSlug},
+		TargetTimestamp:  targetTimestamp,
+	}
+}
+
+func TestDecodeComputeRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		query   url.Values
+		want    computeRequest
+		wantErr bool
+	}{
+		{
+			name:  "bare array",
+			body:  `[{"region_name": "us", "realm_slug": "earthen-ring", "target_timestamp": 1}]`,
+			query: url.Values{},
+			want: computeRequest{
+				Tuples: sotah.RegionRealmTimestampTuples{newTestTuple("us", "earthen-ring", 1)},
+			},
+		},
+		{
+			name:  "null body",
+			body:  `null`,
+			query: url.Values{},
+			want:  computeRequest{Tuples: sotah.RegionRealmTimestampTuples{}},
+		},
+		{
+			name: "envelope with options",
+			body: `{"tuples": [{"region_name": "us", "realm_slug": "aegwynn", "target_timestamp": 2}], ` +
+				`"options": {"dedupe": true}}`,
+			query: url.Values{},
+			want: computeRequest{
+				Tuples:  sotah.RegionRealmTimestampTuples{newTestTuple("us", "aegwynn", 2)},
+				Options: computeOptions{Dedupe: true},
+			},
+		},
+		{
+			name:  "envelope options merged with query options",
+			body:  `{"tuples": [], "options": {"dedupe": true}}`,
+			query: url.Values{"dry_run": []string{"true"}},
+			want: computeRequest{
+				Tuples:  sotah.RegionRealmTimestampTuples{},
+				Options: computeOptions{DryRun: true, Dedupe: true},
+			},
+		},
+		{
+			name:  "normalized casing",
+			body:  `[{"region_name": " US ", "realm_slug": "Earthen-Ring", "target_timestamp": 1}]`,
+			query: url.Values{},
+			want: computeRequest{
+				Tuples: sotah.RegionRealmTimestampTuples{newTestTuple("us", "earthen-ring", 1)},
+			},
+		},
+		{
+			name: "priority ordering",
+			body: `[{"region_name": "us", "realm_slug": "a", "target_timestamp": 1}, ` +
+				`{"region_name": "us", "realm_slug": "b", "target_timestamp": 1, "priority": 5}, ` +
+				`{"region_name": "us", "realm_slug": "c", "target_timestamp": 1}]`,
+			query: url.Values{},
+			want: computeRequest{
+				Tuples: sotah.RegionRealmTimestampTuples{
+					newTestTuple("us", "b", 1),
+					newTestTuple("us", "a", 1),
+					newTestTuple("us", "c", 1),
+				},
+			},
+		},
+		{
+			name:    "unexpected envelope field",
+			body:    `{"tuplez": []}`,
+			query:   url.Values{},
+			wantErr: true,
+		},
+		{
+			name:    "scalar body",
+			body:    `"us"`,
+			query:   url.Values{},
+			wantErr: true,
+		},
+		{
+			name:    "mistyped tuple",
+			body:    `[{"region_name": "us", "realm_slug": "a", "target_timestamp": "1"}]`,
+			query:   url.Values{},
+			wantErr: true,
+		},
+		{
+			name:    "empty body",
+			body:    ``,
+			query:   url.Values{},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeComputeRequest(strings.NewReader(test.body), test.query)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeComputeRequestDecodeErrorIndex(t *testing.T) {
+	body := `[{"region_name": "us", "realm_slug": "a", "target_timestamp": 1}, {"target_timestamp": "1"}]`
+	_, err := decodeComputeRequest(strings.NewReader(body), url.Values{})
+
+	decodeErr, ok := err.(tupleDecodeError)
+	if !ok {
+		t.Fatalf("expected a tuple decode error, got %v", err)
+	}
+	if decodeErr.Index != 1 {
+		t.Errorf("got index %d, want 1", decodeErr.Index)
+	}
+	if decodeErr.Field != "target_timestamp" {
+		t.Errorf("got field %s, want target_timestamp", decodeErr.Field)
+	}
+}
+
+func TestSplitDuplicateTuples(t *testing.T) {
+	tests := []struct {
+		name           string
+		tuples         sotah.RegionRealmTimestampTuples
+		wantUnique     sotah.RegionRealmTimestampTuples
+		wantDuplicates sotah.RegionRealmTimestampTuples
+	}{
+		{
+			name:           "empty",
+			tuples:         sotah.RegionRealmTimestampTuples{},
+			wantUnique:     sotah.RegionRealmTimestampTuples{},
+			wantDuplicates: sotah.RegionRealmTimestampTuples{},
+		},
+		{
+			name:           "no duplicates",
+			tuples:         sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1), newTestTuple("us", "a", 2)},
+			wantUnique:     sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1), newTestTuple("us", "a", 2)},
+			wantDuplicates: sotah.RegionRealmTimestampTuples{},
+		},
+		{
+			name: "repeats after the first occurrence",
+			tuples: sotah.RegionRealmTimestampTuples{
+				newTestTuple("us", "a", 1),
+				newTestTuple("eu", "a", 1),
+				newTestTuple("us", "a", 1),
+				newTestTuple("us", "a", 1),
+			},
+			wantUnique: sotah.RegionRealmTimestampTuples{newTestTuple("us", "a", 1), newTestTuple("eu", "a", 1)},
+			wantDuplicates: sotah.RegionRealmTimestampTuples{
+				newTestTuple("us", "a", 1),
+				newTestTuple("us", "a", 1),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unique, duplicates := splitDuplicateTuples(test.tuples)
+			if !reflect.DeepEqual(unique, test.wantUnique) {
+				t.Errorf("got unique %+v, want %+v", unique, test.wantUnique)
+			}
+			if !reflect.DeepEqual(duplicates, test.wantDuplicates) {
+				t.Errorf("got duplicates %+v, want %+v", duplicates, test.wantDuplicates)
+			}
+		})
+	}
+}
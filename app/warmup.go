@@ -0,0 +1,59 @@
+package app
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sotah-inc/steamwheedle-cartel/pkg/logging"
+)
+
+// autoWarmup is whether the instance warms itself up after init
+var autoWarmup bool
+
+// warmupRetryInterval is how long the warmup goroutine waits before retrying a failed warmup
+const warmupRetryInterval = 10 * time.Second
+
+// warmedUp is set once the warmup goroutine has produced the gateway state and pre-loaded realm data
+var warmedUp int32
+
+// warmup produces the gateway state and pre-loads realm data, so that the first request does not pay for either
+// the gateway holds no blizzard token, as blizzard is only called by the act services
+// a failed warmup is retried until it succeeds, so that the instance does not stay unready for good
+func warmup() {
+	for !warmupOnce() {
+		time.Sleep(warmupRetryInterval)
+	}
+}
+
+func warmupOnce() bool {
+	startTime := time.Now()
+
+	sta, err := resolveState()
+	if err != nil {
+		logging.WithField("error", err.Error()).Error("Failed to warm up gateway state")
+
+		return false
+	}
+
+	regionRealms, err := getMismatchCatalog(sta)
+	if err != nil {
+		logging.WithField("error", err.Error()).Error("Failed to warm up realm data")
+
+		return false
+	}
+
+	atomic.StoreInt32(&warmedUp, 1)
+
+	logging.WithFields(logrus.Fields{
+		"realms":         regionRealms.TotalRealms(),
+		"duration-in-ms": int64(time.Since(startTime) / time.Millisecond),
+	}).Info("Finished warming up")
+
+	return true
+}
+
+// isReady reports whether the instance may be considered ready, which is always when auto-warmup is disabled
+func isReady() bool {
+	return !autoWarmup || atomic.LoadInt32(&warmedUp) == 1
+}